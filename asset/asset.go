@@ -2,14 +2,58 @@
 
 package asset
 
-import "math/big"
+import (
+	"errors"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrNotFound is returned if an asset doesn't exist.
+var ErrNotFound = errors.New("asset not found")
+
+// CodeNotFound is the stable, machine-readable code of ErrNotFound, e.g., for
+// API responses.
+const CodeNotFound = "asset_not_found"
 
 type (
 	Storage interface {
-		Get(id *big.Int) ([]byte, error)
+		// Get opens the asset with the given id. The returned Asset must be
+		// closed after use.
+		Get(id *big.Int) (Asset, error)
+	}
+
+	// WritableStorage is a Storage to which assets can be added.
+	WritableStorage interface {
+		Storage
+
+		// Put stores the asset read from data and returns its id. Assets are
+		// content-addressed, so storing an identical asset again returns the id
+		// of the already stored asset. contentType is the asset's MIME type. If
+		// empty, it is detected from the content. uploader, if not the zero
+		// address, is added to the asset's uploaders.
+		Put(data io.Reader, contentType string, uploader common.Address) (*big.Int, error)
+
+		// Uploaders returns the addresses that uploaded the asset with the
+		// given id. It is empty for unknown assets and assets that were not
+		// uploaded with Put.
+		Uploaders(id *big.Int) ([]common.Address, error)
+	}
+
+	// Asset is an opened asset that can be read and seeked.
+	Asset interface {
+		io.ReadSeekCloser
+		// Size returns the asset's size in bytes.
+		Size() int64
+		// ModTime returns the asset's last modification time.
+		ModTime() time.Time
+		// ContentType returns the asset's MIME type.
+		ContentType() string
 	}
 
 	NoStorage struct{}
 )
 
-func (NoStorage) Get(*big.Int) ([]byte, error) { return nil, nil }
+func (NoStorage) Get(*big.Int) (Asset, error) { return nil, ErrNotFound }
@@ -3,18 +3,63 @@
 package asset
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"math/big"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// DigestDir is the subdirectory of a FileStorage in which uploaded assets are
+// stored under the hex encoding of their SHA-256 digest. The asset's id file
+// `{id}.{ext}`, with the extension of its content type, is a symbolic link to
+// the digest file. The content type is stored next to the digest file with
+// suffix TypeSuffix and the addresses of the uploaders, one per line, with
+// suffix UploadersSuffix.
+const (
+	DigestDir       = "sha256"
+	TypeSuffix      = ".type"
+	UploadersSuffix = ".uploaders"
 )
 
+// DefaultReindexInterval is the minimal time between two indexings of a
+// FileStorage's directory on requests of unknown ids, see
+// FileStorage.SetReindexInterval.
+const DefaultReindexInterval = 10 * time.Second
+
+// FileStorage serves the assets in a directory. Asset files are named
+// `{id}.{ext}`, where id is a base-10 integer and the extension may differ
+// between assets, or just `{id}`. The directory is indexed on creation and
+// again when a requested id is not found, but at most once per reindex
+// interval.
 type FileStorage struct {
-	dir       fs.FS
-	extension string
+	dir             fs.FS
+	path            string
+	reindexInterval time.Duration
+
+	mu        sync.Mutex
+	indexed   time.Time                   // time of the last indexing
+	files     map[string]string           // id -> file name
+	digests   map[string]*big.Int         // hex digest -> id
+	types     map[string]string           // id -> uploaded content type
+	uploaders map[string][]common.Address // id -> uploaders
+	nextID    *big.Int
 }
 
+var _ WritableStorage = (*FileStorage)(nil)
+
 func NewFileStorage(dirpath string) (*FileStorage, error) {
 	dir := os.DirFS(dirpath)
 	dirf, err := dir.Open(".")
@@ -28,27 +73,340 @@ func NewFileStorage(dirpath string) (*FileStorage, error) {
 		return nil, fmt.Errorf("file '%s' is not a directory", dirpath)
 	}
 
-	return &FileStorage{dir: dir}, nil
+	s := &FileStorage{dir: dir, path: dirpath, reindexInterval: DefaultReindexInterval}
+	if err := s.index(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-func (s *FileStorage) SetExtension(ext string) {
-	s.extension = ext
+// SetReindexInterval sets the minimal time between two indexings of the
+// directory on requests of unknown ids. If 0, every such request indexes the
+// directory.
+func (s *FileStorage) SetReindexInterval(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reindexInterval = d
 }
 
-func (s *FileStorage) dotExt() string {
-	if s.extension == "" {
-		return ""
+// Get opens the asset file of the given id. If the id is unknown or its file
+// is gone, the directory is indexed again before ErrNotFound is returned,
+// unless it was indexed less than the reindex interval ago.
+func (s *FileStorage) Get(id *big.Int) (Asset, error) {
+	a, err := s.open(id, false)
+	if errors.Is(err, ErrNotFound) {
+		a, err = s.open(id, true)
 	}
-	return "." + s.extension
+	return a, err
 }
 
-func (s *FileStorage) Get(id *big.Int) ([]byte, error) {
-	fname := id.Text(10) + s.dotExt()
-	f, err := s.dir.Open(fname)
+// open opens the asset file of the given id, indexing the directory first if
+// reindex is set and the reindex interval passed since the last indexing.
+func (s *FileStorage) open(id *big.Int, reindex bool) (Asset, error) {
+	s.mu.Lock()
+	var err error
+	if reindex && time.Since(s.indexed) >= s.reindexInterval {
+		err = s.index()
+	}
+	fname, ok := s.files[id.Text(10)]
+	ctype := s.types[id.Text(10)]
+	s.mu.Unlock()
 	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("%w: no file for id %v", ErrNotFound, id)
+	}
+
+	f, err := s.dir.Open(fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: opening file '%s': %v", ErrNotFound, fname, err)
+	} else if err != nil {
 		return nil, fmt.Errorf("opening file '%s': %w", fname, err)
 	}
-	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading stats '%s': %w", fname, err)
+	} else if info.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%w: file '%s' is a directory", ErrNotFound, fname)
+	}
+	rsc, ok := f.(io.ReadSeekCloser)
+	if !ok {
+		f.Close()
+		return nil, fmt.Errorf("file '%s' is not seekable", fname)
+	}
+
+	if ctype == "" {
+		if ctype, err = detectContentType(fname, rsc); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("detecting content type of '%s': %w", fname, err)
+		}
+	}
+	return &fileAsset{ReadSeekCloser: rsc, info: info, ctype: ctype}, nil
+}
+
+// fileAsset is an opened asset file of a FileStorage.
+type fileAsset struct {
+	io.ReadSeekCloser
+	info  fs.FileInfo
+	ctype string
+}
+
+func (a *fileAsset) Size() int64         { return a.info.Size() }
+func (a *fileAsset) ModTime() time.Time  { return a.info.ModTime() }
+func (a *fileAsset) ContentType() string { return a.ctype }
+
+// detectContentType determines the content type of file fname from its
+// extension or, if the extension is unknown, from the first bytes of its
+// content f, which is rewound afterwards.
+func detectContentType(fname string, f io.ReadSeeker) (string, error) {
+	if ctype := mime.TypeByExtension(filepath.Ext(fname)); ctype != "" {
+		return ctype, nil
+	}
+	var buf [512]byte // http.DetectContentType considers at most 512 bytes
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// Put stores the asset read from data under its SHA-256 digest in DigestDir
+// and links it to the next free id, which follows the largest indexed id up to
+// math.MaxUint64. It fails if that id is used. If contentType is empty, it is
+// detected from the content. If an asset with the same digest was already
+// stored, its id is returned instead and contentType is ignored. The uploader
+// is recorded in either case.
+func (s *FileStorage) Put(data io.Reader, contentType string, uploader common.Address) (*big.Int, error) {
+	digestDir := filepath.Join(s.path, DigestDir)
+	if err := os.MkdirAll(digestDir, 0755); err != nil {
+		return nil, fmt.Errorf("creating digest directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(digestDir, ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after successful rename
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("writing asset: %w", err)
+	}
+	if contentType == "" {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("rewinding asset: %w", err)
+		}
+		if contentType, err = detectContentType("", tmp); err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("detecting content type: %w", err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("syncing asset: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("closing asset: %w", err)
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.digests[digest]; ok {
+		if err := s.addUploader(id, digest, uploader); err != nil {
+			return nil, err
+		}
+		return new(big.Int).Set(id), nil
+	}
+	if !s.nextID.IsUint64() {
+		return nil, fmt.Errorf("no free asset id: id %d is used", uint64(math.MaxUint64))
+	}
+
+	typeFile := filepath.Join(digestDir, digest+TypeSuffix)
+	if err := os.WriteFile(typeFile, []byte(contentType), 0644); err != nil {
+		return nil, fmt.Errorf("writing content type: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(digestDir, digest)); err != nil {
+		return nil, fmt.Errorf("renaming asset: %w", err)
+	}
+	id := new(big.Int).Set(s.nextID)
+	fname := id.Text(10) + extensionByType(contentType)
+	if err := os.Symlink(filepath.Join(DigestDir, digest), filepath.Join(s.path, fname)); err != nil {
+		return nil, fmt.Errorf("linking asset '%s': %w", fname, err)
+	}
+	s.files[id.Text(10)] = fname
+	s.digests[digest] = id
+	s.types[id.Text(10)] = contentType
+	s.nextID.Add(s.nextID, big.NewInt(1))
+	if err := s.addUploader(id, digest, uploader); err != nil {
+		return nil, err
+	}
+	return new(big.Int).Set(id), nil
+}
+
+// extensionByType returns the file extension, including the dot, of MIME type
+// ctype, or "" if it has none.
+func extensionByType(ctype string) string {
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return ""
+	} else if ext, ok := preferredExts[mediatype]; ok {
+		return ext
+	}
+	exts, err := mime.ExtensionsByType(mediatype)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
+// preferredExts are the extensions of uploaded assets of types with multiple
+// common extensions, of which mime.ExtensionsByType doesn't return the usual
+// one first.
+var preferredExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"text/plain": ".txt",
+}
+
+// addUploader records uploader as uploader of the asset with the given id and
+// digest, unless it is the zero address or already recorded. The caller must
+// hold s.mu.
+func (s *FileStorage) addUploader(id *big.Int, digest string, uploader common.Address) error {
+	key := id.Text(10)
+	if uploader == (common.Address{}) || containsAddress(s.uploaders[key], uploader) {
+		return nil
+	}
+	fname := filepath.Join(s.path, DigestDir, digest+UploadersSuffix)
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("opening uploaders file: %w", err)
+	}
+	if _, err := f.WriteString(uploader.Hex() + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("writing uploader: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing uploaders file: %w", err)
+	}
+	s.uploaders[key] = append(s.uploaders[key], uploader)
+	return nil
+}
+
+// Uploaders returns the recorded uploaders of the asset with the given id.
+func (s *FileStorage) Uploaders(id *big.Int) ([]common.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]common.Address(nil), s.uploaders[id.Text(10)]...), nil
+}
+
+// index scans the storage directory for asset files and the digests of
+// uploaded assets. Files that don't start with a base-10 id are ignored. It is
+// an error if multiple files have the same id, either ambiguously with
+// different extensions, like `1.png` and `1.gif`, or duplicated with a
+// different spelling, like `1.png` and `01.png`. The caller must hold s.mu,
+// unless s is not yet shared.
+func (s *FileStorage) index() error {
+	s.indexed = time.Now() // also throttle failing indexings
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return fmt.Errorf("reading directory '%s': %w", s.path, err)
+	}
+
+	files := make(map[string]string)
+	digests := make(map[string]*big.Int)
+	types := make(map[string]string)
+	uploaders := make(map[string][]common.Address)
+	nextID := big.NewInt(1) // id 0 is reserved
+	for _, e := range entries {
+		id, ok := parseID(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		key := id.Text(10)
+		if other, ok := files[key]; ok {
+			if idPart(other) == idPart(e.Name()) {
+				return fmt.Errorf("ambiguous asset id %v: files '%s' and '%s'", id, other, e.Name())
+			}
+			return fmt.Errorf("duplicate asset id %v: files '%s' and '%s'", id, other, e.Name())
+		}
+		files[key] = e.Name()
+		// Larger ids can't be set as asset id of an NFT, so they don't
+		// determine the id of uploaded assets.
+		if id.IsUint64() && id.Cmp(nextID) >= 0 {
+			nextID.Add(id, big.NewInt(1))
+		}
+
+		if e.Type()&fs.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(s.path, e.Name()))
+		if err != nil {
+			return fmt.Errorf("reading link '%s': %w", e.Name(), err)
+		}
+		dir, digest := filepath.Split(target)
+		if filepath.Clean(dir) != DigestDir {
+			continue
+		}
+		if _, ok := digests[digest]; !ok {
+			digests[digest] = id
+		}
+		ctype, err := os.ReadFile(filepath.Join(s.path, DigestDir, digest+TypeSuffix))
+		if err == nil {
+			types[key] = string(ctype)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("reading content type of '%s': %w", e.Name(), err)
+		}
+		if uploaders[key], err = readUploaders(filepath.Join(s.path, DigestDir, digest+UploadersSuffix)); err != nil {
+			return fmt.Errorf("reading uploaders of '%s': %w", e.Name(), err)
+		}
+	}
+	s.files, s.digests, s.types, s.uploaders, s.nextID = files, digests, types, uploaders, nextID
+	return nil
+}
+
+// readUploaders reads the uploaders file fname. A missing file means no
+// uploaders.
+func readUploaders(fname string) (uploaders []common.Address, _ error) {
+	data, err := os.ReadFile(fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// Skip the empty last line and partially written lines.
+		if common.IsHexAddress(line) {
+			uploaders = append(uploaders, common.HexToAddress(line))
+		}
+	}
+	return uploaders, nil
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// parseID parses the id of asset file fname, which is the part before the
+// first dot and must only consist of decimal digits.
+func parseID(fname string) (*big.Int, bool) {
+	idstr := idPart(fname)
+	if idstr == "" || strings.Trim(idstr, "0123456789") != "" {
+		return nil, false
+	}
+	return new(big.Int).SetString(idstr, 10)
+}
 
-	return io.ReadAll(f)
+func idPart(fname string) string {
+	return strings.SplitN(fname, ".", 2)[0]
 }
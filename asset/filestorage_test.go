@@ -3,10 +3,18 @@
 package asset_test
 
 import (
+	"io"
 	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/require"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/erdstall/eth"
 
 	"github.com/perun-network/nerd-op/asset"
 )
@@ -29,7 +37,7 @@ func TestFileStorage(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, s)
 		_, err = s.Get(big.NewInt(123))
-		require.Error(t, err)
+		require.ErrorIs(t, err, asset.ErrNotFound)
 
 		for _, id := range []string{
 			"1",
@@ -37,19 +45,17 @@ func TestFileStorage(t *testing.T) {
 			"18446744073709551616",
 		} {
 			t.Log("id:", id)
-			data, err := s.Get(bigIntStr(id))
-			require.NoError(t, err)
+			data := readAsset(t, s, bigIntStr(id))
 			require.Equal(t, append([]byte(id), '\n'), data)
 		}
 	})
 
 	t.Run("with-ext", func(t *testing.T) {
 		s, err := asset.NewFileStorage("testdata/withext")
-		s.SetExtension("a")
 		require.NoError(t, err)
 		require.NotNil(t, s)
 		_, err = s.Get(big.NewInt(123))
-		require.Error(t, err)
+		require.ErrorIs(t, err, asset.ErrNotFound)
 
 		for _, id := range []string{
 			"1",
@@ -58,11 +64,162 @@ func TestFileStorage(t *testing.T) {
 		} {
 			t.Log("id:", id)
 			idn := bigIntStr(id)
-			data, err := s.Get(idn)
-			require.NoError(t, err)
+			data := readAsset(t, s, idn)
 			require.Len(t, data, int(idn.Int64()+1))
 		}
 	})
+
+	t.Run("put", func(t *testing.T) {
+		rng := ptest.Prng(t)
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "41.asset"), []byte("41"), 0666))
+		s, err := asset.NewFileStorage(dir)
+		require.NoError(t, err)
+
+		uploader, other := eth.NewRandomAddress(rng), eth.NewRandomAddress(rng)
+		id, err := s.Put(strings.NewReader("foo"), "", uploader)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(42), id)
+		require.Equal(t, []byte("foo"), readAsset(t, s, id))
+
+		id, err = s.Put(strings.NewReader("bar"), "image/svg+xml", eth.Zero)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(43), id)
+		id, err = s.Put(strings.NewReader("foo"), "", other)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(42), id, "identical asset deduplicated")
+		requireUploaders(t, s, id, uploader, other)
+		requireUploaders(t, s, big.NewInt(41))
+
+		// reopened storage remembers the digests
+		s, err = asset.NewFileStorage(dir)
+		require.NoError(t, err)
+		id, err = s.Put(strings.NewReader("bar"), "", eth.Zero)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(43), id)
+		requireContentType(t, s, id, "image/svg+xml")
+		requireContentType(t, s, big.NewInt(42), "text/plain; charset=utf-8")
+		requireUploaders(t, s, big.NewInt(42), uploader, other)
+		requireUploaders(t, s, big.NewInt(43))
+		id, err = s.Put(strings.NewReader("baz"), "", eth.Zero)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(44), id)
+		requireContentType(t, s, id, "text/plain; charset=utf-8")
+
+		// links are named with the extension of the content type
+		for _, fname := range []string{"42.txt", "43.svg", "44.txt"} {
+			_, err := os.Lstat(filepath.Join(dir, fname))
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("id-space", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "18446744073709551616"), nil, 0666))
+		s, err := asset.NewFileStorage(dir)
+		require.NoError(t, err)
+		id, err := s.Put(strings.NewReader("foo"), "", eth.Zero)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(1), id, "ids above MaxUint64 ignored")
+
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "18446744073709551615"), nil, 0666))
+		s, err = asset.NewFileStorage(dir)
+		require.NoError(t, err)
+		_, err = s.Put(strings.NewReader("bar"), "", eth.Zero)
+		require.Error(t, err, "id space exhausted")
+		id, err = s.Put(strings.NewReader("foo"), "", eth.Zero)
+		require.NoError(t, err)
+		require.Equal(t, big.NewInt(1), id, "existing asset still found")
+	})
+
+	t.Run("content-type", func(t *testing.T) {
+		png := []byte("\x89PNG\x0D\x0A\x1A\x0Aimage data")
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "1.png"), []byte("by extension"), 0666))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "2"), png, 0666))
+
+		s, err := asset.NewFileStorage(dir)
+		require.NoError(t, err)
+		requireContentType(t, s, big.NewInt(1), "image/png")
+		requireContentType(t, s, big.NewInt(2), "image/png")
+		require.Equal(t, png, readAsset(t, s, big.NewInt(2)), "content rewound after detection")
+	})
+
+	t.Run("mixed-ext", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, fname := range []string{"1.png", "2.gif", "3.mp4", "4", "5.tar.gz", "README", ".hidden"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, fname), []byte(fname), 0666))
+		}
+		s, err := asset.NewFileStorage(dir)
+		require.NoError(t, err)
+		s.SetReindexInterval(0)
+		for i, fname := range []string{"1.png", "2.gif", "3.mp4", "4", "5.tar.gz"} {
+			require.Equal(t, []byte(fname), readAsset(t, s, big.NewInt(int64(i+1))))
+		}
+		requireContentType(t, s, big.NewInt(2), "image/gif")
+
+		// files added later are found on demand
+		_, err = s.Get(big.NewInt(6))
+		require.ErrorIs(t, err, asset.ErrNotFound)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "6.webm"), []byte("6"), 0666))
+		require.Equal(t, []byte("6"), readAsset(t, s, big.NewInt(6)))
+	})
+
+	t.Run("reindex-interval", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := asset.NewFileStorage(dir)
+		require.NoError(t, err)
+
+		// unknown ids don't reindex within the interval
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "1.png"), []byte("1"), 0666))
+		_, err = s.Get(big.NewInt(1))
+		require.ErrorIs(t, err, asset.ErrNotFound)
+
+		s.SetReindexInterval(0)
+		require.Equal(t, []byte("1"), readAsset(t, s, big.NewInt(1)))
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		for _, fnames := range [][]string{
+			{"1.png", "1.gif"},
+			{"1.png", "1"},
+			{"1.png", "01.png"},
+		} {
+			dir := t.TempDir()
+			for _, fname := range fnames {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, fname), nil, 0666))
+			}
+			s, err := asset.NewFileStorage(dir)
+			require.Error(t, err, fnames)
+			require.Nil(t, s)
+		}
+	})
+}
+
+func readAsset(t *testing.T, s asset.Storage, id *big.Int) []byte {
+	t.Helper()
+	a, err := s.Get(id)
+	require.NoError(t, err)
+	defer a.Close()
+	data, err := io.ReadAll(a)
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), a.Size())
+	return data
+}
+
+func requireContentType(t *testing.T, s asset.Storage, id *big.Int, ctype string) {
+	t.Helper()
+	a, err := s.Get(id)
+	require.NoError(t, err)
+	defer a.Close()
+	require.Equal(t, ctype, a.ContentType())
+}
+
+func requireUploaders(t *testing.T, s asset.WritableStorage, id *big.Int, uploaders ...common.Address) {
+	t.Helper()
+	recorded, err := s.Uploaders(id)
+	require.NoError(t, err)
+	require.ElementsMatch(t, uploaders, recorded)
 }
 
 func bigIntStr(s string) *big.Int {
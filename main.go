@@ -3,7 +3,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 
@@ -42,9 +48,14 @@ func main() {
 	if err != nil {
 		log.Fatalf("Main: error opening assets storage: %v", err)
 	}
-	ast.SetExtension(servCfg.Assets.Ext)
 	log.Info("Assets storage opened")
 
+	nfts, closeNFTs, err := openNFTStorage(servCfg.Storage)
+	if err != nil {
+		log.Fatalf("Main: error opening NFT storage: %v", err)
+	}
+	log.Infof("NFT storage (%s) opened", servCfg.Storage.Type)
+
 	op := operator.SetupWithPrototypeEnclave(cfg, nil)
 	go func() {
 		if err := op.Serve(cfg.RPCPort); err != nil {
@@ -52,11 +63,65 @@ func main() {
 		}
 	}()
 
-	serv := nftserv.New(nft.NewMemory(), ast, servCfg.Server)
+	serv := nftserv.New(nfts, ast, servCfg.Server)
 	// inject new balances from operator
 	op.OnNewBalance(serv.UpdateBalance)
 	addr := servCfg.Server.Addr()
-	if err := serv.Serve(); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serv.Serve()
+	}()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	failed := false
+	select {
+	case sig := <-sigs:
+		log.Infof("Main: received %v, shutting down", sig)
+	case err := <-serveErr:
 		log.Errorf("Main: NFTServer.ListenAndServe(%s) stopped with error %v", addr, err)
+		failed = true
+	}
+	// A second signal kills the process without waiting for the shutdown.
+	signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+
+	// Shut down in order: first drain in-flight requests and detach the
+	// server from the operator's balance updates, then flush the NFT storage.
+	// The operator has no shutdown API and stops with the process.
+	ctx, cancel := context.WithTimeout(context.Background(), servCfg.Server.ShutdownTimeoutDuration())
+	defer cancel()
+	if err := serv.Shutdown(ctx); err != nil {
+		log.Errorf("Main: error shutting down NFT server: %v", err)
+		failed = true
+	}
+	if !failed {
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Main: NFTServer.ListenAndServe(%s) stopped with error %v", addr, err)
+			failed = true
+		}
+	}
+	closeNFTs()
+	log.Info("Shutdown complete")
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// openNFTStorage opens the NFT storage configured in cfg. The returned function
+// closes the storage.
+func openNFTStorage(cfg nftserv.StorageConfig) (nft.Storage, func(), error) {
+	switch cfg.Type {
+	case nftserv.StorageTypeJournal:
+		j, err := nft.OpenJournal(cfg.Path, cfg.CompactThreshold)
+		if err != nil {
+			return nil, nil, err
+		}
+		return j, func() {
+			if err := j.Close(); err != nil {
+				log.Errorf("Main: error closing NFT journal: %v", err)
+			}
+		}, nil
+	default:
+		return nft.NewMemory(), func() {}, nil
 	}
 }
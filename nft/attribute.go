@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Display types of numeric attributes, see OpenSea metadata standards.
+const (
+	DisplayNumber          = "number"
+	DisplayBoostNumber     = "boost_number"
+	DisplayBoostPercentage = "boost_percentage"
+	DisplayDate            = "date"
+)
+
+// MaxAttributes is the maximal number of attributes of an NFT.
+const MaxAttributes = 100
+
+// Attribute is a trait of an NFT in the format of OpenSea metadata
+// attributes.
+type Attribute struct {
+	// TraitType is the name of the trait. It may be empty for generic
+	// attributes that only have a value.
+	TraitType string `json:"trait_type,omitempty"`
+	// Value is either a string or, for numeric traits, a float64.
+	Value interface{} `json:"value"`
+	// DisplayType, if set, is one of DisplayNumber, DisplayBoostNumber,
+	// DisplayBoostPercentage or DisplayDate and requires a numeric Value.
+	// Dates are unix timestamps in seconds.
+	DisplayType string `json:"display_type,omitempty"`
+	// MaxValue, if set, is the maximal Value of a numeric trait.
+	MaxValue *float64 `json:"max_value,omitempty"`
+}
+
+// Validate checks that a is a well-formed attribute.
+func (a Attribute) Validate() error {
+	var num float64
+	switch v := a.Value.(type) {
+	case string:
+		if a.DisplayType != "" || a.MaxValue != nil {
+			return fmt.Errorf("attribute %q: display_type and max_value require a numeric value", a.TraitType)
+		}
+		return nil
+	case float64:
+		num = v
+	case nil:
+		return fmt.Errorf("attribute %q: missing value", a.TraitType)
+	default:
+		return fmt.Errorf("attribute %q: value must be a string or number", a.TraitType)
+	}
+
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		return fmt.Errorf("attribute %q: value is not a finite number", a.TraitType)
+	}
+	switch a.DisplayType {
+	case "", DisplayNumber, DisplayBoostNumber, DisplayBoostPercentage, DisplayDate:
+	default:
+		return fmt.Errorf("attribute %q: unknown display_type %q", a.TraitType, a.DisplayType)
+	}
+	if a.MaxValue != nil && num > *a.MaxValue {
+		return fmt.Errorf("attribute %q: value %v exceeds max_value %v", a.TraitType, num, *a.MaxValue)
+	}
+	return nil
+}
+
+// ValueString returns the value of a as string. Numbers are formatted in their
+// shortest decimal representation.
+func (a Attribute) ValueString() string {
+	switch v := a.Value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Equal reports whether a and o are equal. Values of invalid attributes, like
+// JSON arrays or objects, are compared deeply.
+func (a Attribute) Equal(o Attribute) bool {
+	return a.TraitType == o.TraitType && valuesEqual(a.Value, o.Value) && a.DisplayType == o.DisplayType &&
+		((a.MaxValue == nil && o.MaxValue == nil) ||
+			(a.MaxValue != nil && o.MaxValue != nil && *a.MaxValue == *o.MaxValue))
+}
+
+// valuesEqual reports whether attribute values x and y are equal. Unlike ==,
+// it doesn't panic on non-comparable values, like []interface{}.
+func valuesEqual(x, y interface{}) bool {
+	switch xv := x.(type) {
+	case string:
+		yv, ok := y.(string)
+		return ok && xv == yv
+	case float64:
+		yv, ok := y.(float64)
+		return ok && xv == yv
+	default:
+		return reflect.DeepEqual(x, y)
+	}
+}
+
+// ValidateAttributes validates all attributes and checks that there are at
+// most MaxAttributes and that trait types are unique.
+func ValidateAttributes(attrs []Attribute) error {
+	if len(attrs) > MaxAttributes {
+		return fmt.Errorf("more than %d attributes", MaxAttributes)
+	}
+	types := make(map[string]struct{}, len(attrs))
+	for _, a := range attrs {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+		if a.TraitType == "" {
+			continue
+		}
+		if _, ok := types[a.TraitType]; ok {
+			return fmt.Errorf("duplicate attribute %q", a.TraitType)
+		}
+		types[a.TraitType] = struct{}{}
+	}
+	return nil
+}
+
+// HasTrait reports whether attrs contain an attribute with the given trait type
+// and value, as returned by Attribute.ValueString.
+func HasTrait(attrs []Attribute, traitType, value string) bool {
+	for _, a := range attrs {
+		if a.TraitType == traitType && a.ValueString() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func attributesEqual(a, b []Attribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/nerd-op/nft"
+	"github.com/perun-network/nerd-op/nft/test"
+)
+
+func TestAttributes(t *testing.T) {
+	max := 10.0
+	for _, tc := range []struct {
+		attr  nft.Attribute
+		valid bool
+	}{
+		{nft.Attribute{TraitType: "Color", Value: "Blue"}, true},
+		{nft.Attribute{Value: "generic"}, true},
+		{nft.Attribute{TraitType: "Level", Value: 5.0, DisplayType: nft.DisplayBoostNumber, MaxValue: &max}, true},
+		{nft.Attribute{TraitType: "Birthday", Value: 1546360800.0, DisplayType: nft.DisplayDate}, true},
+		{nft.Attribute{TraitType: "Level", Value: 11.0, MaxValue: &max}, false},
+		{nft.Attribute{TraitType: "Color", Value: "Blue", DisplayType: nft.DisplayNumber}, false},
+		{nft.Attribute{TraitType: "Level", Value: 1.0, DisplayType: "fancy"}, false},
+		{nft.Attribute{TraitType: "Level", Value: math.Inf(1)}, false},
+		{nft.Attribute{TraitType: "Flag", Value: true}, false},
+		{nft.Attribute{TraitType: "Missing"}, false},
+	} {
+		if tc.valid {
+			require.NoError(t, tc.attr.Validate(), tc.attr)
+		} else {
+			require.Error(t, tc.attr.Validate(), tc.attr)
+		}
+	}
+
+	// non-comparable values of invalid attributes don't panic
+	list := nft.Attribute{TraitType: "Colors", Value: []interface{}{"Blue", "Red"}}
+	require.True(t, list.Equal(nft.Attribute{TraitType: "Colors", Value: []interface{}{"Blue", "Red"}}))
+	require.False(t, list.Equal(nft.Attribute{TraitType: "Colors", Value: []interface{}{"Blue"}}))
+	require.False(t, list.Equal(nft.Attribute{TraitType: "Colors", Value: "Blue"}))
+	require.False(t, nft.Attribute{TraitType: "Level", Value: 1.0}.Equal(nft.Attribute{TraitType: "Level", Value: "1"}))
+
+	dup := []nft.Attribute{{TraitType: "Color", Value: "Blue"}, {TraitType: "Color", Value: "Red"}}
+	require.Error(t, nft.ValidateAttributes(dup))
+	require.NoError(t, nft.ValidateAttributes(dup[:1]))
+
+	t.Run("json", func(t *testing.T) {
+		rng := ptest.Prng(t)
+		tkn := test.NewRandomNFT(rng)
+		tkn.Attributes = []nft.Attribute{
+			{TraitType: "Color", Value: "Blue"},
+			{TraitType: "Level", Value: 5.0, DisplayType: nft.DisplayNumber, MaxValue: &max},
+		}
+		data, err := json.Marshal(tkn)
+		require.NoError(t, err)
+		require.Contains(t, string(data),
+			`"attributes":[{"trait_type":"Color","value":"Blue"},{"trait_type":"Level","value":5,"display_type":"number","max_value":10}]`)
+		var dec nft.NFT
+		require.NoError(t, json.Unmarshal(data, &dec))
+		require.Equal(t, tkn, dec)
+
+		tkn.Attributes = nil
+		data, err = json.Marshal(tkn)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), "attributes")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		rng := ptest.Prng(t)
+		tkn := test.NewRandomNFT(rng)
+		tkn.Attributes = []nft.Attribute{{TraitType: "Color", Value: "Blue"}}
+
+		upd := nft.NFT{Token: tkn.Token, ID: tkn.ID}
+		tkn.Update(upd)
+		require.Len(t, tkn.Attributes, 1, "nil attributes are kept")
+
+		upd.Attributes = []nft.Attribute{{TraitType: "Color", Value: "Red"}}
+		tkn.Update(upd)
+		require.Equal(t, upd.Attributes, tkn.Attributes)
+		upd.Attributes[0].Value = "Green"
+		require.Equal(t, "Red", tkn.Attributes[0].Value, "attributes are copied")
+
+		upd.Attributes = []nft.Attribute{}
+		tkn.Update(upd)
+		require.Empty(t, tkn.Attributes, "empty attributes clear")
+	})
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// MaxRoyaltyBasisPoints is the maximal royalty, 100%, in basis points.
+const MaxRoyaltyBasisPoints = 10000
+
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// CodeCollectionNotFound is the code of ErrCollectionNotFound, see ErrorCode.
+const CodeCollectionNotFound = "collection_not_found"
+
+type (
+	// Collection is the metadata of an ERC721 token contract.
+	Collection struct {
+		Token       common.Address `json:"token"`
+		Name        string         `json:"name"`
+		Symbol      string         `json:"symbol"`
+		Description string         `json:"description"`
+		// BannerAssetID and LogoAssetID are ids in the assets storage. A value
+		// of 0 indicates no asset is set.
+		BannerAssetID uint           `json:"bannerAssetId,omitempty"`
+		LogoAssetID   uint           `json:"logoAssetId,omitempty"`
+		Creator       common.Address `json:"creator"`
+		Royalty       *Royalty       `json:"royalty,omitempty"`
+	}
+
+	// Royalty is the royalty paid to Receiver on sales, in basis points of the
+	// sale price.
+	Royalty struct {
+		Receiver    common.Address `json:"receiver"`
+		BasisPoints uint16         `json:"basisPoints"`
+	}
+)
+
+// Validate checks that the royalty doesn't exceed MaxRoyaltyBasisPoints.
+func (r Royalty) Validate() error {
+	if r.BasisPoints > MaxRoyaltyBasisPoints {
+		return fmt.Errorf("royalty of %d basis points exceeds %d", r.BasisPoints, MaxRoyaltyBasisPoints)
+	}
+	return nil
+}
+
+// Amount returns the royalty amount on a sale at salePrice, computed like the
+// EIP-2981 reference implementation as salePrice * BasisPoints / 10000,
+// rounding down.
+func (r Royalty) Amount(salePrice *big.Int) *big.Int {
+	amount := new(big.Int).Mul(salePrice, big.NewInt(int64(r.BasisPoints)))
+	return amount.Quo(amount, big.NewInt(MaxRoyaltyBasisPoints))
+}
+
+// RoyaltyOf returns the royalty of tkn, which is its own royalty, if set, or
+// else the royalty of its collection coll, which may be nil. If neither is
+// set, nil is returned.
+func RoyaltyOf(tkn NFT, coll *Collection) *Royalty {
+	if tkn.Royalty != nil {
+		return tkn.Royalty
+	} else if coll != nil {
+		return coll.Royalty
+	}
+	return nil
+}
+
+// Validate checks that c's royalty, if set, is valid.
+func (c Collection) Validate() error {
+	if c.Royalty != nil {
+		return c.Royalty.Validate()
+	}
+	return nil
+}
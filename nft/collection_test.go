@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/erdstall/eth"
+
+	"github.com/perun-network/nerd-op/nft"
+	"github.com/perun-network/nerd-op/nft/test"
+)
+
+func TestRoyalty(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		rng    = ptest.Prng(t)
+		tkn    = test.NewRandomNFT(rng)
+		r      = nft.Royalty{Receiver: eth.NewRandomAddress(rng), BasisPoints: 250}
+	)
+
+	assert.NoError(r.Validate())
+	assert.Error(nft.Royalty{BasisPoints: nft.MaxRoyaltyBasisPoints + 1}.Validate())
+
+	// amounts are rounded down
+	assert.Equal(big.NewInt(25), r.Amount(big.NewInt(1000)))
+	assert.Equal(big.NewInt(2), r.Amount(big.NewInt(100)))
+	assert.Zero(r.Amount(big.NewInt(39)).Sign())
+
+	tkn.Royalty = nil
+	assert.Nil(nft.RoyaltyOf(tkn, nil))
+	coll := nft.Collection{Token: tkn.Token}
+	assert.Nil(nft.RoyaltyOf(tkn, &coll))
+	coll.Royalty = &r
+	assert.Equal(&r, nft.RoyaltyOf(tkn, &coll))
+	// the NFT's royalty overrides the collection's
+	override := nft.Royalty{Receiver: tkn.Owner, BasisPoints: 1000}
+	tkn.Royalty = &override
+	assert.Equal(&override, nft.RoyaltyOf(tkn, &coll))
+
+	// Update only sets the royalty if given
+	upd := tkn
+	upd.Update(nft.NFT{Token: tkn.Token, ID: tkn.ID, Title: "new"})
+	assert.Equal(&override, upd.Royalty)
+	upd.Update(nft.NFT{Token: tkn.Token, ID: tkn.ID, Royalty: &r})
+	assert.Equal(&r, upd.Royalty)
+}
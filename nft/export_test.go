@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import "errors"
+
+// ErrInjected is returned by journal files after FailNextJournalWrite.
+var ErrInjected = errors.New("injected write error")
+
+// FailNextJournalWrite makes the next write to j's file fail with ErrInjected
+// after writing n bytes of the record, simulating a full disk.
+func FailNextJournalWrite(j *Journal, n int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.file = &failingFile{journalFile: j.file, n: n}
+}
+
+// failingFile fails the first write after writing n bytes.
+type failingFile struct {
+	journalFile
+	n      int
+	failed bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	if f.failed {
+		return f.journalFile.Write(p)
+	}
+	f.failed = true
+	if f.n > len(p) {
+		f.n = len(p)
+	}
+	n, err := f.journalFile.Write(p[:f.n])
+	if err != nil {
+		return n, err
+	}
+	return n, ErrInjected
+}
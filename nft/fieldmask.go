@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Metadata fields of an NFT that can be set explicitly with a FieldMask. They
+// are named like their JSON keys.
+const (
+	FieldAssetID    = "assetId"
+	FieldSecret     = "secret"
+	FieldTitle      = "title"
+	FieldDesc       = "desc"
+	FieldAttributes = "attributes"
+	// FieldRoyalty can be set explicitly to remove a royalty override, but it
+	// cannot be patched by the owner with ParseMergePatch.
+	FieldRoyalty = "royalty"
+)
+
+// FieldMask lists metadata fields of an NFT that an update sets explicitly,
+// see NFT.UpdateFields.
+type FieldMask []string
+
+// Validate checks that m only contains known metadata fields.
+func (m FieldMask) Validate() error {
+	for _, f := range m {
+		switch f {
+		case FieldAssetID, FieldSecret, FieldTitle, FieldDesc, FieldAttributes, FieldRoyalty:
+		default:
+			return fmt.Errorf("unknown field %q", f)
+		}
+	}
+	return nil
+}
+
+// ParseMergePatch parses a JSON Merge Patch (RFC 7396) of the metadata of
+// NFT (token, id). It returns an NFT holding the patched values and the mask
+// of patched fields, to be used with NFT.UpdateFields or
+// Storage.UpsertFields. A value of null clears a field.
+//
+// The patch must be a JSON object and can only contain the metadata fields
+// listed in the Field constants, except FieldRoyalty.
+func ParseMergePatch(token common.Address, id *big.Int, patch []byte) (NFT, FieldMask, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return NFT{}, nil, fmt.Errorf("patch is not a JSON object: %w", err)
+	} else if fields == nil {
+		return NFT{}, nil, fmt.Errorf("patch is not a JSON object")
+	}
+
+	src := NFT{Token: token, ID: id}
+	mask := make(FieldMask, 0, len(fields))
+	for f, v := range fields {
+		// null doesn't change the zero value, which clears the field.
+		var err error
+		switch f {
+		case FieldAssetID:
+			err = json.Unmarshal(v, &src.AssetID)
+		case FieldSecret:
+			err = json.Unmarshal(v, &src.Secret)
+		case FieldTitle:
+			err = json.Unmarshal(v, &src.Title)
+		case FieldDesc:
+			err = json.Unmarshal(v, &src.Desc)
+		case FieldAttributes:
+			err = json.Unmarshal(v, &src.Attributes)
+		default:
+			return NFT{}, nil, fmt.Errorf("field %q cannot be patched", f)
+		}
+		if err != nil {
+			return NFT{}, nil, fmt.Errorf("decoding field %q: %w", f, err)
+		}
+		mask = append(mask, f)
+	}
+	sort.Strings(mask)
+	return src, mask, nil
+}
@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/nerd-op/nft"
+	"github.com/perun-network/nerd-op/nft/test"
+)
+
+func TestMergePatch(t *testing.T) {
+	var (
+		rng = ptest.Prng(t)
+		tkn = test.NewRandomNFT(rng)
+		m   = nft.NewMemory()
+	)
+	tkn.AssetID, tkn.Secret, tkn.Title, tkn.Desc = 1, true, "title", "desc"
+	tkn.Attributes = []nft.Attribute{{TraitType: "Color", Value: "Blue"}}
+	require.NoError(t, m.Upsert(tkn))
+
+	t.Run("parse", func(t *testing.T) {
+		for _, patch := range []string{`[]`, `null`, `"title"`, `{"state":"owned"}`, `{"owner":null}`, `{"secret":"no"}`, `{"royalty":null}`} {
+			_, _, err := nft.ParseMergePatch(tkn.Token, tkn.ID, []byte(patch))
+			assert.Errorf(t, err, "patch %s", patch)
+		}
+
+		src, mask, err := nft.ParseMergePatch(tkn.Token, tkn.ID, []byte(`{"title":"new","secret":false,"desc":null}`))
+		require.NoError(t, err)
+		assert.Equal(t, nft.FieldMask{nft.FieldDesc, nft.FieldSecret, nft.FieldTitle}, mask)
+		assert.NoError(t, mask.Validate())
+		assert.Equal(t, nft.NFT{Token: tkn.Token, ID: tkn.ID, Title: "new"}, src)
+		assert.Error(t, nft.FieldMask{"owner"}.Validate())
+	})
+
+	t.Run("update", func(t *testing.T) {
+		upd := tkn
+		// without mask, zero values are ignored
+		upd.UpdateFields(nft.NFT{Token: tkn.Token, ID: tkn.ID}, nil)
+		assert.Equal(t, tkn, upd)
+		upd.UpdateFields(nft.NFT{Token: tkn.Token, ID: tkn.ID, Title: "new"},
+			nft.FieldMask{nft.FieldAssetID, nft.FieldSecret, nft.FieldDesc, nft.FieldAttributes})
+		assert.Equal(t, tkn.Owner, upd.Owner)
+		assert.Equal(t, "new", upd.Title)
+		assert.Zero(t, upd.AssetID)
+		assert.False(t, upd.Secret)
+		assert.Empty(t, upd.Desc)
+		assert.Nil(t, upd.Attributes)
+
+		upd.Royalty = &nft.Royalty{Receiver: tkn.Owner, BasisPoints: 250}
+		upd.UpdateFields(nft.NFT{Token: tkn.Token, ID: tkn.ID}, nil)
+		assert.NotNil(t, upd.Royalty)
+		upd.UpdateFields(nft.NFT{Token: tkn.Token, ID: tkn.ID}, nft.FieldMask{nft.FieldRoyalty})
+		assert.Nil(t, upd.Royalty)
+	})
+
+	t.Run("storage", func(t *testing.T) {
+		src, mask, err := nft.ParseMergePatch(tkn.Token, tkn.ID, []byte(`{"secret":false,"attributes":null}`))
+		require.NoError(t, err)
+		assert.ErrorIs(t, m.CompareAndUpsertFields(nft.NFT{Token: tkn.Token, ID: tkn.ID}, src, mask), nft.ErrConflict)
+		require.NoError(t, m.CompareAndUpsertFields(tkn, src, mask))
+
+		get, err := m.Get(tkn.Token, tkn.ID)
+		require.NoError(t, err)
+		assert.False(t, get.Secret)
+		assert.Nil(t, get.Attributes)
+		assert.Equal(t, tkn.Title, get.Title)
+		revs, err := m.Revisions(tkn.Token, tkn.ID)
+		require.NoError(t, err)
+		assert.Len(t, revs, 2)
+	})
+}
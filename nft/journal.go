@@ -0,0 +1,346 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCompactThreshold is the minimal number of journal records after
+// which a Journal is compacted.
+const DefaultCompactThreshold = 1024
+
+var _ Storage = (*Journal)(nil)
+
+// Journal is a persistent Storage. All NFTs are held in a Memory storage and
+// every change is additionally appended to a journal file, one JSON-encoded
+// record per line. Each record holds the full state of an NFT after the change
+// together with the ownership changes and metadata revisions to append, so
+// replaying a journal boils down to overwriting entries in order.
+//
+// A record is only considered written after the file was synced. Changes are
+// only applied to the memory storage after their record was written. If
+// writing a record fails, the journal is truncated to its previous size. A
+// partially written last record, as may be left behind by a crash, is
+// discarded when the journal is opened.
+//
+// The journal is compacted once the number of records exceeds twice the
+// number of stored NFTs, but not before the compaction threshold is reached.
+// Compaction writes a fresh journal to a temporary file and atomically
+// replaces the old journal.
+type Journal struct {
+	mu        sync.Mutex // serializes writes to mem and file
+	mem       *Memory
+	path      string
+	file      journalFile
+	records   int
+	threshold int
+	// err is set if the journal file couldn't be restored after a failed
+	// write. All further writes fail with it.
+	err error
+}
+
+// journalFile is the file a Journal appends to. It is an *os.File, except in
+// tests.
+type journalFile interface {
+	io.WriteSeeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// OpenJournal opens the journal at path, creating it if it doesn't exist yet,
+// and restores all NFTs from it. A compactThreshold <= 0 is replaced by
+// DefaultCompactThreshold.
+func OpenJournal(path string, compactThreshold int) (*Journal, error) {
+	if compactThreshold <= 0 {
+		compactThreshold = DefaultCompactThreshold
+	}
+	j := &Journal{
+		mem:       NewMemory(),
+		path:      path,
+		threshold: compactThreshold,
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal '%s': %w", path, err)
+	}
+	valid, err := j.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replaying journal '%s': %w", path, err)
+	}
+	// Discard a partially written last record.
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("truncating journal '%s': %w", path, err)
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("seeking journal '%s': %w", path, err)
+	}
+	j.file = f
+
+	if j.needsCompaction() {
+		if err := j.compact(); err != nil {
+			j.file.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// replay restores all records from r into the memory storage. It returns the
+// offset after the last complete record.
+func (j *Journal) replay(r io.Reader) (valid int64, _ error) {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Warnf("Journal: discarding incomplete last record of '%s'", j.path)
+			}
+			return valid, nil
+		} else if err != nil {
+			return valid, err
+		}
+
+		var rec record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			return valid, fmt.Errorf("decoding record at offset %d: %w", valid, err)
+		} else if rec.NFT == nil && rec.Collection == nil {
+			return valid, fmt.Errorf("record at offset %d holds neither NFT nor collection", valid)
+		}
+		j.mem.restore(rec)
+		j.records++
+		valid += int64(len(line))
+	}
+}
+
+func (j *Journal) Upsert(nft NFT) error {
+	return j.upsert(nft, nil, nil)
+}
+
+func (j *Journal) CompareAndUpsert(old, nft NFT) error {
+	return j.upsert(nft, nil, &old)
+}
+
+func (j *Journal) UpsertFields(nft NFT, mask FieldMask) error {
+	return j.upsert(nft, mask, nil)
+}
+
+func (j *Journal) CompareAndUpsertFields(old, nft NFT, mask FieldMask) error {
+	return j.upsert(nft, mask, &old)
+}
+
+func (j *Journal) upsert(nft NFT, mask FieldMask, expected *NFT) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.mem.upsert(nft, mask, expected, time.Now(), j.append); err != nil {
+		return err
+	}
+	j.maybeCompact()
+	return nil
+}
+
+func (j *Journal) Rollback(token common.Address, id *big.Int, rev uint64) (NFT, error) {
+	return j.rollback(token, id, rev, nil)
+}
+
+func (j *Journal) CompareAndRollback(old NFT, rev uint64) (NFT, error) {
+	return j.rollback(old.Token, old.ID, rev, &old)
+}
+
+func (j *Journal) rollback(token common.Address, id *big.Int, rev uint64, expected *NFT) (NFT, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec, err := j.mem.rollback(token, id, rev, expected, time.Now(), j.append)
+	if err != nil {
+		return NFT{}, err
+	}
+	j.maybeCompact()
+	return *rec.NFT, nil
+}
+
+func (j *Journal) Get(token common.Address, id *big.Int) (NFT, error) {
+	return j.mem.Get(token, id)
+}
+
+func (j *Journal) History(token common.Address, id *big.Int) ([]OwnerChange, error) {
+	return j.mem.History(token, id)
+}
+
+func (j *Journal) Revisions(token common.Address, id *big.Int) ([]Revision, error) {
+	return j.mem.Revisions(token, id)
+}
+
+func (j *Journal) Revision(token common.Address, id *big.Int, rev uint64) (Revision, error) {
+	return j.mem.Revision(token, id, rev)
+}
+
+func (j *Journal) GetAll() ([]NFT, error) {
+	return j.mem.GetAll()
+}
+
+func (j *Journal) GetByOwner(owner common.Address) ([]NFT, error) {
+	return j.mem.GetByOwner(owner)
+}
+
+func (j *Journal) GetByToken(token common.Address) ([]NFT, error) {
+	return j.mem.GetByToken(token)
+}
+
+func (j *Journal) GetByAsset(assetID uint) ([]NFT, error) {
+	return j.mem.GetByAsset(assetID)
+}
+
+func (j *Journal) Scan(q Query, fn func(NFT) bool) error {
+	return j.mem.Scan(q, fn)
+}
+
+func (j *Journal) PutCollection(c Collection) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.mem.putCollection(c, j.append); err != nil {
+		return err
+	}
+	j.maybeCompact()
+	return nil
+}
+
+func (j *Journal) GetCollection(token common.Address) (Collection, error) {
+	return j.mem.GetCollection(token)
+}
+
+// Close closes the journal file. The Journal must not be used afterwards.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// append writes rec to the journal file. If writing fails, the file is
+// truncated to its previous size, so that no partial record remains in the
+// middle of the journal.
+func (j *Journal) append(rec record) error {
+	if j.err != nil {
+		return j.err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding record: %w", err)
+	}
+	offset, err := j.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("getting journal offset: %w", err)
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return j.undoAppend(offset, fmt.Errorf("writing record: %w", err))
+	}
+	if err := j.file.Sync(); err != nil {
+		return j.undoAppend(offset, fmt.Errorf("syncing journal: %w", err))
+	}
+	j.records++
+	return nil
+}
+
+// undoAppend truncates the journal file to offset after a failed append with
+// error err, which is returned. If the journal file can't be truncated, the
+// journal is marked as broken and refuses all further writes.
+func (j *Journal) undoAppend(offset int64, err error) error {
+	if terr := j.file.Truncate(offset); terr != nil {
+		j.err = fmt.Errorf("journal '%s' broken after failed append (%v): truncating: %w", j.path, err, terr)
+	} else if _, serr := j.file.Seek(offset, io.SeekStart); serr != nil {
+		j.err = fmt.Errorf("journal '%s' broken after failed append (%v): seeking: %w", j.path, err, serr)
+	}
+	if j.err != nil {
+		log.Error(j.err)
+		return j.err
+	}
+	return err
+}
+
+// maybeCompact compacts the journal if needed. Errors are only logged since
+// all records are already persisted, so compaction can be retried later.
+func (j *Journal) maybeCompact() {
+	if !j.needsCompaction() {
+		return
+	}
+	if err := j.compact(); err != nil {
+		log.Errorf("Journal: error compacting '%s': %v", j.path, err)
+	}
+}
+
+func (j *Journal) needsCompaction() bool {
+	return j.records >= j.threshold && j.records > 2*(j.mem.TotalSize()+len(j.mem.collections))
+}
+
+// compact writes all current NFTs with their histories and revisions and all
+// collections to a temporary file and atomically replaces the journal file
+// with it.
+func (j *Journal) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".compact-*")
+	if err != nil {
+		return fmt.Errorf("creating compaction file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after successful rename
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	var n int
+	if err := j.mem.forEach(func(rec record) error {
+		n++
+		return enc.Encode(rec)
+	}); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing compaction record: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("flushing compaction file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("syncing compaction file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("replacing journal: %w", err)
+	}
+	syncDir(filepath.Dir(j.path))
+
+	j.file.Close()
+	j.file = tmp // positioned at end of file
+	j.records = n
+	return nil
+}
+
+// syncDir syncs directory dir so that a preceding rename is persisted.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		log.Warnf("Journal: error opening directory '%s' for syncing: %v", dir, err)
+		return
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		log.Warnf("Journal: error syncing directory '%s': %v", dir, err)
+	}
+}
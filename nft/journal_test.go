@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/erdstall/eth"
+
+	"github.com/perun-network/nerd-op/nft"
+	"github.com/perun-network/nerd-op/nft/test"
+)
+
+func TestJournal(t *testing.T) {
+	var (
+		rng  = ptest.Prng(t)
+		path = filepath.Join(t.TempDir(), "nfts.journal")
+		tkn  = test.NewRandomNFT(rng)
+	)
+
+	j, err := nft.OpenJournal(path, 0)
+	require.NoError(t, err)
+
+	empty, err := j.Get(tkn.Token, tkn.ID)
+	assert.ErrorIs(t, err, nft.ErrNotFound)
+	assert.Equal(t, empty, nft.NFT{})
+
+	require.NoError(t, j.Upsert(tkn))
+	get, err := j.Get(tkn.Token, tkn.ID)
+	require.NoError(t, err)
+	assert.Equal(t, tkn, get)
+
+	tkn.Owner = eth.NewRandomAddress(rng)
+	tkn.Title, tkn.Secret = "persisted", true
+	require.NoError(t, j.Upsert(tkn))
+	// cleared fields are persisted, too
+	tkn.Secret = false
+	require.NoError(t, j.UpsertFields(tkn, nft.FieldMask{nft.FieldSecret}))
+	coll := nft.Collection{
+		Token:   tkn.Token,
+		Name:    "Persisted Collection",
+		Creator: eth.NewRandomAddress(rng),
+		Royalty: &nft.Royalty{Receiver: eth.NewRandomAddress(rng), BasisPoints: 250},
+	}
+	require.NoError(t, j.PutCollection(coll))
+	// unchanged NFTs aren't persisted again
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, j.Upsert(tkn))
+	}
+	_, err = j.Rollback(tkn.Token, tkn.ID, 3)
+	require.NoError(t, err)
+	unchanged, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.Size(), unchanged.Size())
+	require.NoError(t, j.Close())
+
+	t.Run("reopen", func(t *testing.T) {
+		assert, require := assert.New(t), require.New(t)
+		j, err := nft.OpenJournal(path, 0)
+		require.NoError(err)
+		defer j.Close()
+		get, err := j.Get(tkn.Token, tkn.ID)
+		require.NoError(err)
+		assert.Equal(tkn, get)
+		all, err := j.GetAll()
+		require.NoError(err)
+		assert.Len(all, 1)
+		hist, err := j.History(tkn.Token, tkn.ID)
+		require.NoError(err)
+		require.Len(hist, 2)
+		assert.Equal(tkn.Owner, hist[1].To)
+		getColl, err := j.GetCollection(tkn.Token)
+		require.NoError(err)
+		assert.Equal(coll, getColl)
+	})
+
+	t.Run("torn-write", func(t *testing.T) {
+		assert, require := assert.New(t), require.New(t)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(err)
+		_, err = f.WriteString(`{"token":"0x`)
+		require.NoError(err)
+		require.NoError(f.Close())
+
+		j, err := nft.OpenJournal(path, 0)
+		require.NoError(err)
+		get, err := j.Get(tkn.Token, tkn.ID)
+		require.NoError(err)
+		assert.Equal(tkn, get)
+
+		// appending after a discarded record must yield a valid journal
+		tkn2 := test.NewRandomNFT(rng)
+		require.NoError(j.Upsert(tkn2))
+		require.NoError(j.Close())
+		j, err = nft.OpenJournal(path, 0)
+		require.NoError(err)
+		defer j.Close()
+		get, err = j.Get(tkn2.Token, tkn2.ID)
+		require.NoError(err)
+		assert.Equal(tkn2, get)
+	})
+}
+
+func TestJournalWriteFailure(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		require = require.New(t)
+		rng     = ptest.Prng(t)
+		path    = filepath.Join(t.TempDir(), "nfts.journal")
+		tkn     = test.NewRandomNFT(rng)
+	)
+
+	j, err := nft.OpenJournal(path, 0)
+	require.NoError(err)
+	require.NoError(j.Upsert(tkn))
+
+	// a torn write neither changes the memory state nor corrupts the journal
+	changed := tkn
+	changed.Owner, changed.Title = eth.NewRandomAddress(rng), "lost"
+	nft.FailNextJournalWrite(j, 10)
+	require.ErrorIs(j.Upsert(changed), nft.ErrInjected)
+	get, err := j.Get(tkn.Token, tkn.ID)
+	require.NoError(err)
+	assert.Equal(tkn, get)
+	hist, err := j.History(tkn.Token, tkn.ID)
+	require.NoError(err)
+	assert.Len(hist, 1)
+	revs, err := j.Revisions(tkn.Token, tkn.ID)
+	require.NoError(err)
+	assert.Len(revs, 1)
+
+	nft.FailNextJournalWrite(j, 10)
+	require.ErrorIs(j.PutCollection(nft.Collection{Token: tkn.Token}), nft.ErrInjected)
+	_, err = j.GetCollection(tkn.Token)
+	assert.ErrorIs(err, nft.ErrCollectionNotFound)
+
+	// later changes are persisted after the failed ones
+	tkn.Title = "persisted"
+	require.NoError(j.Upsert(tkn))
+	require.NoError(j.Close())
+
+	j, err = nft.OpenJournal(path, 0)
+	require.NoError(err)
+	defer j.Close()
+	get, err = j.Get(tkn.Token, tkn.ID)
+	require.NoError(err)
+	assert.Equal(tkn, get)
+	revs, err = j.Revisions(tkn.Token, tkn.ID)
+	require.NoError(err)
+	assert.Len(revs, 2)
+}
+
+func TestJournalCompaction(t *testing.T) {
+	var (
+		require   = require.New(t)
+		rng       = ptest.Prng(t)
+		path      = filepath.Join(t.TempDir(), "nfts.journal")
+		threshold = 8
+		tkns      = []nft.NFT{test.NewRandomNFT(rng), test.NewRandomNFT(rng)}
+	)
+
+	j, err := nft.OpenJournal(path, threshold)
+	require.NoError(err)
+	coll := nft.Collection{Token: tkns[0].Token, Name: "Compacted"}
+	require.NoError(j.PutCollection(coll))
+	for i := 0; i < 10*threshold; i++ {
+		tkn := &tkns[i%len(tkns)]
+		tkn.Owner = eth.NewRandomAddress(rng)
+		require.NoError(j.Upsert(*tkn))
+	}
+	require.NoError(j.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(err)
+	// compaction must have kept the journal from growing with every upsert
+	require.Less(countLines(data), 2*threshold)
+
+	j, err = nft.OpenJournal(path, threshold)
+	require.NoError(err)
+	defer j.Close()
+	for _, tkn := range tkns {
+		get, err := j.Get(tkn.Token, tkn.ID)
+		require.NoError(err)
+		require.Equal(tkn, get)
+	}
+	getColl, err := j.GetCollection(coll.Token)
+	require.NoError(err)
+	require.Equal(coll, getColl)
+}
+
+func countLines(data []byte) (n int) {
+	for _, b := range data {
+		if b == '\n' {
+			n++
+		}
+	}
+	return
+}
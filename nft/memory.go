@@ -3,35 +3,177 @@
 package nft
 
 import (
+	"bytes"
 	"math/big"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 )
 
 var _ Storage = (*Memory)(nil)
 
-type Memory struct {
-	mu  sync.RWMutex
-	mem map[common.Address]map[string]*NFT
-}
+type (
+	Memory struct {
+		mu  sync.RWMutex
+		mem map[common.Address]map[string]*NFT
+		// sorted holds all NFTs, ordered by token and id, see Compare.
+		sorted []*NFT
+		// owners is a secondary index of all NFTs by owner, each ordered like
+		// sorted.
+		owners map[common.Address][]*NFT
+		// assets is a secondary index of all NFTs with an asset by asset id,
+		// keyed by key(token, id).
+		assets map[uint]map[string]*NFT
+		// history holds the ownership history of all NFTs, keyed by key(token, id).
+		history map[string][]OwnerChange
+		// revisions holds the metadata revisions of all NFTs, keyed by key(token, id).
+		revisions map[string][]Revision
+		// collections holds the collection metadata, keyed by token.
+		collections map[common.Address]*Collection
+	}
+
+	// record holds the state of an NFT together with (parts of) its ownership
+	// history and metadata revisions, or the state of a collection. It is used
+	// to describe changes to and snapshots of a Memory storage entry.
+	record struct {
+		NFT        *NFT          `json:"nft,omitempty"`
+		History    []OwnerChange `json:"history,omitempty"`
+		Revisions  []Revision    `json:"revisions,omitempty"`
+		Collection *Collection   `json:"collection,omitempty"`
+	}
+)
 
 func NewMemory() *Memory {
 	return &Memory{
-		mem: make(map[common.Address]map[string]*NFT),
+		mem:         make(map[common.Address]map[string]*NFT),
+		owners:      make(map[common.Address][]*NFT),
+		assets:      make(map[uint]map[string]*NFT),
+		history:     make(map[string][]OwnerChange),
+		revisions:   make(map[string][]Revision),
+		collections: make(map[common.Address]*Collection),
 	}
 }
 
 func (m *Memory) Upsert(nft NFT) error {
+	_, err := m.upsert(nft, nil, nil, time.Now(), nil)
+	return err
+}
+
+func (m *Memory) CompareAndUpsert(old, nft NFT) error {
+	_, err := m.upsert(nft, nil, &old, time.Now(), nil)
+	return err
+}
+
+func (m *Memory) UpsertFields(nft NFT, mask FieldMask) error {
+	_, err := m.upsert(nft, mask, nil, time.Now(), nil)
+	return err
+}
+
+func (m *Memory) CompareAndUpsertFields(old, nft NFT, mask FieldMask) error {
+	_, err := m.upsert(nft, mask, &old, time.Now(), nil)
+	return err
+}
+
+// persistFunc persists a change record before it is applied to a Memory
+// storage. If it fails, the change is not applied.
+type persistFunc func(record) error
+
+// upsert upserts nft, explicitly setting the fields in mask, and returns a
+// record of the resulting NFT together with the ownership change and metadata
+// revision recorded at time now, if any. If the stored NFT doesn't change,
+// nothing is recorded or persisted.
+//
+// If expected is not nil, nft is only upserted if the stored NFT equals
+// expected. Otherwise ErrConflict is returned.
+//
+// If persist is not nil, it is called with the record before the change is
+// applied. If it fails, the storage is left unchanged and its error returned.
+func (m *Memory) upsert(nft NFT, mask FieldMask, expected *NFT, now time.Time, persist persistFunc) (record, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if exnft, ok := m.get(nft.Token, nft.ID); ok {
-		exnft.Update(nft)
-		return nil
+	var (
+		oldOwner common.Address
+		oldMeta  Revision
+		newnft   = nft
+	)
+	exnft, ok := m.get(nft.Token, nft.ID)
+	if expected != nil && (!ok || !exnft.Equal(*expected)) {
+		return record{}, ErrConflict
 	}
-	m.put(nft)
-	return nil
+	if ok {
+		oldOwner, oldMeta = exnft.Owner, revisionOf(exnft)
+		newnft = *exnft
+		newnft.UpdateFields(nft, mask)
+	}
+
+	rec := record{NFT: &newnft}
+	if newnft.Owner != oldOwner {
+		rec.History = []OwnerChange{{From: oldOwner, To: newnft.Owner, Time: now}}
+	}
+	if meta := revisionOf(&newnft); !meta.sameMetadata(oldMeta) {
+		rec.Revisions = []Revision{m.newRevision(newnft.Token, newnft.ID, meta, now)}
+	}
+	if ok && newnft.Equal(*exnft) {
+		return rec.copy(), nil // unchanged, e.g., by a repeated balance update
+	}
+	return m.commit(rec, persist)
+}
+
+// rollback sets the metadata of NFT (token, id) to the metadata of revision
+// rev. If the metadata changes, a new revision is recorded at time now,
+// otherwise nothing is persisted. It returns a record of the resulting NFT and
+// the new revision, if any. expected and persist are handled as by upsert.
+func (m *Memory) rollback(token common.Address, id *big.Int, rev uint64, expected *NFT, now time.Time, persist persistFunc) (record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exnft, ok := m.get(token, id)
+	if !ok {
+		if expected != nil {
+			return record{}, ErrConflict
+		}
+		return record{}, ErrNotFound
+	} else if expected != nil && !exnft.Equal(*expected) {
+		return record{}, ErrConflict
+	}
+	revs := m.revisions[key(token, id)]
+	if rev == 0 || rev > uint64(len(revs)) {
+		return record{}, ErrRevisionNotFound
+	}
+
+	target := revs[rev-1]
+	newnft := *exnft
+	rec := record{NFT: &newnft}
+	if target.sameMetadata(revisionOf(exnft)) {
+		return rec.copy(), nil // unchanged
+	}
+	newnft.AssetID, newnft.Secret, newnft.Title, newnft.Desc, newnft.Attributes =
+		target.AssetID, target.Secret, target.Title, target.Desc, target.Attributes
+	rec.Revisions = []Revision{m.newRevision(token, id, target, now)}
+	return m.commit(rec, persist)
+}
+
+// commit persists rec with persist, if not nil, and then applies it to the
+// storage. It returns a copy of rec. m.mu must be held.
+func (m *Memory) commit(rec record, persist persistFunc) (record, error) {
+	if persist != nil {
+		if err := persist(rec); err != nil {
+			return record{}, err
+		}
+	}
+	m.apply(rec)
+	return rec.copy(), nil
+}
+
+// newRevision returns a copy of meta as the next revision of NFT (token, id)
+// at time now.
+func (m *Memory) newRevision(token common.Address, id *big.Int, meta Revision, now time.Time) Revision {
+	meta.Rev = uint64(len(m.revisions[key(token, id)])) + 1
+	meta.Time = now
+	return meta
 }
 
 func (m *Memory) Get(token common.Address, id *big.Int) (NFT, error) {
@@ -48,14 +190,160 @@ func (m *Memory) Get(token common.Address, id *big.Int) (NFT, error) {
 func (m *Memory) GetAll() (tkns []NFT, _ error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	for _, tnfts := range m.mem {
-		for _, tkn := range tnfts {
-			tkns = append(tkns, *tkn)
-		}
+	for _, tkn := range m.sorted {
+		tkns = append(tkns, *tkn)
+	}
+	return
+}
+
+func (m *Memory) History(token common.Address, id *big.Int) ([]OwnerChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.get(token, id); !ok {
+		return nil, ErrNotFound
+	}
+	return append([]OwnerChange(nil), m.history[key(token, id)]...), nil
+}
+
+func (m *Memory) Revisions(token common.Address, id *big.Int) ([]Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.get(token, id); !ok {
+		return nil, ErrNotFound
+	}
+	return append([]Revision(nil), m.revisions[key(token, id)]...), nil
+}
+
+func (m *Memory) Revision(token common.Address, id *big.Int, rev uint64) (Revision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.get(token, id); !ok {
+		return Revision{}, ErrNotFound
+	}
+	revs := m.revisions[key(token, id)]
+	if rev == 0 || rev > uint64(len(revs)) {
+		return Revision{}, ErrRevisionNotFound
+	}
+	return revs[rev-1], nil
+}
+
+func (m *Memory) Rollback(token common.Address, id *big.Int, rev uint64) (NFT, error) {
+	rec, err := m.rollback(token, id, rev, nil, time.Now(), nil)
+	if err != nil {
+		return NFT{}, err
+	}
+	return *rec.NFT, nil
+}
+
+func (m *Memory) CompareAndRollback(old NFT, rev uint64) (NFT, error) {
+	rec, err := m.rollback(old.Token, old.ID, rev, &old, time.Now(), nil)
+	if err != nil {
+		return NFT{}, err
+	}
+	return *rec.NFT, nil
+}
+
+func (m *Memory) GetByOwner(owner common.Address) (tkns []NFT, _ error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, tkn := range m.owners[owner] {
+		tkns = append(tkns, *tkn)
+	}
+	return
+}
+
+func (m *Memory) GetByToken(token common.Address) (tkns []NFT, _ error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, tkn := range m.tokenRange(token) {
+		tkns = append(tkns, *tkn)
 	}
 	return
 }
 
+func (m *Memory) GetByAsset(assetID uint) (tkns []NFT, _ error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, tkn := range m.assets[assetID] {
+		tkns = append(tkns, *tkn)
+	}
+	return
+}
+
+// Scan only visits the NFTs following the cursor of q, using binary search
+// on the ordered indexes.
+func (m *Memory) Scan(q Query, fn func(NFT) bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	nfts := m.sorted
+	if q.Owner != nil {
+		nfts = m.owners[*q.Owner]
+	} else if q.Token != nil {
+		nfts = m.tokenRange(*q.Token)
+	}
+
+	if !q.Desc {
+		start := 0
+		if q.AfterID != nil {
+			start = sort.Search(len(nfts), func(i int) bool {
+				return Compare(nfts[i].Token, nfts[i].ID, q.AfterToken, q.AfterID) > 0
+			})
+		}
+		for _, tkn := range nfts[start:] {
+			if !fn(*tkn) {
+				break
+			}
+		}
+		return nil
+	}
+
+	end := len(nfts)
+	if q.AfterID != nil {
+		end = searchNFT(nfts, q.AfterToken, q.AfterID)
+	}
+	for i := end - 1; i >= 0; i-- {
+		if !fn(*nfts[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// tokenRange returns the NFTs of token as sub-slice of m.sorted.
+func (m *Memory) tokenRange(token common.Address) []*NFT {
+	start := sort.Search(len(m.sorted), func(i int) bool {
+		return bytes.Compare(m.sorted[i].Token.Bytes(), token.Bytes()) >= 0
+	})
+	end := start + len(m.mem[token])
+	return m.sorted[start:end]
+}
+
+func (m *Memory) PutCollection(c Collection) error {
+	return m.putCollection(c, nil)
+}
+
+// putCollection puts collection c. persist is handled as by upsert.
+func (m *Memory) putCollection(c Collection, persist persistFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.commit(record{Collection: &c}, persist)
+	return err
+}
+
+func (m *Memory) GetCollection(token common.Address) (Collection, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	c, ok := m.collections[token]
+	if !ok {
+		return Collection{}, ErrCollectionNotFound
+	}
+	return *c, nil
+}
+
 func (m *Memory) get(token common.Address, id *big.Int) (*NFT, bool) {
 	tokenNfts, ok := m.mem[token]
 	if !ok {
@@ -71,7 +359,135 @@ func (m *Memory) put(nft NFT) {
 		tokenNfts = make(map[string]*NFT)
 		m.mem[nft.Token] = tokenNfts
 	}
+	if exnft, ok := tokenNfts[string(nft.ID.Bytes())]; ok {
+		m.unindexOwner(exnft)
+		m.unindexAsset(exnft)
+		m.sorted[searchNFT(m.sorted, nft.Token, nft.ID)] = &nft
+	} else {
+		m.sorted = insertNFT(m.sorted, &nft)
+	}
 	tokenNfts[string(nft.ID.Bytes())] = &nft
+	m.indexOwner(&nft)
+	m.indexAsset(&nft)
+}
+
+func (m *Memory) appendHistory(token common.Address, id *big.Int, changes ...OwnerChange) {
+	k := key(token, id)
+	m.history[k] = append(m.history[k], changes...)
+}
+
+func (m *Memory) appendRevisions(token common.Address, id *big.Int, revs ...Revision) {
+	k := key(token, id)
+	m.revisions[k] = append(m.revisions[k], revs...)
+}
+
+// restore puts rec.NFT into the storage, overwriting any existing entry, and
+// appends rec.History and rec.Revisions to its history and revisions. If set,
+// rec.Collection is put into the storage, too.
+func (m *Memory) restore(rec record) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apply(rec)
+}
+
+// apply applies rec to the storage, see restore. m.mu must be held.
+func (m *Memory) apply(rec record) {
+	if rec.NFT != nil {
+		m.put(*rec.NFT)
+		m.appendHistory(rec.NFT.Token, rec.NFT.ID, rec.History...)
+		m.appendRevisions(rec.NFT.Token, rec.NFT.ID, rec.Revisions...)
+	}
+	if rec.Collection != nil {
+		c := *rec.Collection
+		m.collections[c.Token] = &c
+	}
+}
+
+// forEach calls fn with a full record of every NFT in the storage.
+func (m *Memory) forEach(fn func(record) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, tnfts := range m.mem {
+		for _, tkn := range tnfts {
+			k := key(tkn.Token, tkn.ID)
+			rec := record{NFT: tkn, History: m.history[k], Revisions: m.revisions[k]}
+			if err := fn(rec.copy()); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range m.collections {
+		if err := fn(record{Collection: c}.copy()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy returns a copy of the record that doesn't share the NFT or collection
+// with the storage.
+func (r record) copy() record {
+	if r.NFT != nil {
+		nft := *r.NFT
+		r.NFT = &nft
+	}
+	if r.Collection != nil {
+		c := *r.Collection
+		r.Collection = &c
+	}
+	return r
+}
+
+func (m *Memory) indexOwner(nft *NFT) {
+	m.owners[nft.Owner] = insertNFT(m.owners[nft.Owner], nft)
+}
+
+func (m *Memory) unindexOwner(nft *NFT) {
+	ownerNfts := m.owners[nft.Owner]
+	i := searchNFT(ownerNfts, nft.Token, nft.ID)
+	ownerNfts = append(ownerNfts[:i], ownerNfts[i+1:]...)
+	if len(ownerNfts) == 0 {
+		delete(m.owners, nft.Owner)
+	} else {
+		m.owners[nft.Owner] = ownerNfts
+	}
+}
+
+func (m *Memory) indexAsset(nft *NFT) {
+	if nft.AssetID == 0 {
+		return
+	}
+	assetNfts, ok := m.assets[nft.AssetID]
+	if !ok {
+		assetNfts = make(map[string]*NFT)
+		m.assets[nft.AssetID] = assetNfts
+	}
+	assetNfts[key(nft.Token, nft.ID)] = nft
+}
+
+func (m *Memory) unindexAsset(nft *NFT) {
+	assetNfts := m.assets[nft.AssetID]
+	delete(assetNfts, key(nft.Token, nft.ID))
+	if len(assetNfts) == 0 {
+		delete(m.assets, nft.AssetID)
+	}
+}
+
+// searchNFT returns the index of the first NFT in the ordered nfts that is
+// not before NFT (token, id).
+func searchNFT(nfts []*NFT, token common.Address, id *big.Int) int {
+	return sort.Search(len(nfts), func(i int) bool {
+		return Compare(nfts[i].Token, nfts[i].ID, token, id) >= 0
+	})
+}
+
+// insertNFT inserts nft into the ordered nfts and returns the extended slice.
+func insertNFT(nfts []*NFT, nft *NFT) []*NFT {
+	i := searchNFT(nfts, nft.Token, nft.ID)
+	nfts = append(nfts, nil)
+	copy(nfts[i+1:], nfts[i:])
+	nfts[i] = nft
+	return nfts
 }
 
 func (m *Memory) TotalSize() (n int) {
@@ -5,6 +5,7 @@ package nft_test
 import (
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/stretchr/testify/assert"
 	ptest "perun.network/go-perun/pkg/test"
 
@@ -39,3 +40,190 @@ func TestNFTMemory(t *testing.T) {
 	assert.Equal(get, tkn)
 	assert.Equal(m.TotalSize(), 1)
 }
+
+func TestNFTMemoryHistory(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		rng    = ptest.Prng(t)
+		tkn    = test.NewRandomNFT(rng)
+		m      = nft.NewMemory()
+	)
+
+	_, err := m.History(tkn.Token, tkn.ID)
+	assert.ErrorIs(err, nft.ErrNotFound)
+
+	owners := []common.Address{tkn.Owner}
+	assert.NoError(m.Upsert(tkn))
+	// metadata-only updates don't change the history
+	tkn.Title = "title"
+	assert.NoError(m.Upsert(tkn))
+	tkn.Owner = eth.NewRandomAddress(rng)
+	owners = append(owners, tkn.Owner)
+	assert.NoError(m.Upsert(tkn))
+
+	hist, err := m.History(tkn.Token, tkn.ID)
+	assert.NoError(err)
+	assert.Len(hist, len(owners))
+	from := eth.Zero
+	for i, change := range hist {
+		assert.Equal(from, change.From)
+		assert.Equal(owners[i], change.To)
+		assert.False(change.Time.IsZero())
+		from = change.To
+	}
+}
+
+func TestNFTMemoryIndexes(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		rng    = ptest.Prng(t)
+		m      = nft.NewMemory()
+		owner  = eth.NewRandomAddress(rng)
+		token  = eth.NewRandomAddress(rng)
+		tkns   = make([]nft.NFT, 4)
+	)
+
+	for i := range tkns {
+		tkns[i] = test.NewRandomNFT(rng)
+		tkns[i].Owner = owner
+		if i%2 == 0 {
+			tkns[i].Token = token
+		}
+		assert.NoError(m.Upsert(tkns[i]))
+	}
+
+	byOwner, err := m.GetByOwner(owner)
+	assert.NoError(err)
+	assert.ElementsMatch(tkns, byOwner)
+
+	byToken, err := m.GetByToken(token)
+	assert.NoError(err)
+	assert.ElementsMatch([]nft.NFT{tkns[0], tkns[2]}, byToken)
+
+	// owner changes must be reflected in the owner index
+	newOwner := eth.NewRandomAddress(rng)
+	tkns[0].Owner = newOwner
+	assert.NoError(m.Upsert(tkns[0]))
+	byOwner, err = m.GetByOwner(owner)
+	assert.NoError(err)
+	assert.ElementsMatch(tkns[1:], byOwner)
+	byOwner, err = m.GetByOwner(newOwner)
+	assert.NoError(err)
+	assert.Equal([]nft.NFT{tkns[0]}, byOwner)
+
+	empty, err := m.GetByToken(eth.NewRandomAddress(rng))
+	assert.NoError(err)
+	assert.Empty(empty)
+
+	// asset changes must be reflected in the asset index
+	tkns[1].AssetID, tkns[2].AssetID = 7, 7
+	assert.NoError(m.Upsert(tkns[1]))
+	assert.NoError(m.Upsert(tkns[2]))
+	byAsset, err := m.GetByAsset(7)
+	assert.NoError(err)
+	assert.ElementsMatch(tkns[1:3], byAsset)
+	tkns[1].AssetID = 8
+	assert.NoError(m.Upsert(tkns[1]))
+	byAsset, err = m.GetByAsset(7)
+	assert.NoError(err)
+	assert.Equal([]nft.NFT{tkns[2]}, byAsset)
+}
+
+func TestNFTMemoryRevisions(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		rng    = ptest.Prng(t)
+		tkn    = test.NewRandomNFT(rng)
+		m      = nft.NewMemory()
+	)
+
+	_, err := m.Revisions(tkn.Token, tkn.ID)
+	assert.ErrorIs(err, nft.ErrNotFound)
+
+	tkn.Title = "first"
+	assert.NoError(m.Upsert(tkn))
+	// owner-only updates don't create a revision
+	tkn.Owner = eth.NewRandomAddress(rng)
+	assert.NoError(m.Upsert(tkn))
+	tkn.Title, tkn.Desc = "second", "desc"
+	assert.NoError(m.Upsert(tkn))
+
+	// unvalidated, non-comparable attribute values don't panic
+	tkn.Attributes = []nft.Attribute{{TraitType: "Colors", Value: []interface{}{"Blue"}}}
+	assert.NoError(m.Upsert(tkn))
+	assert.NoError(m.Upsert(tkn))
+
+	revs, err := m.Revisions(tkn.Token, tkn.ID)
+	assert.NoError(err)
+	assert.Len(revs, 3)
+	for i, rev := range revs {
+		assert.Equal(uint64(i+1), rev.Rev)
+	}
+	assert.Equal("first", revs[0].Title)
+	assert.Empty(revs[0].Desc)
+
+	_, err = m.Revision(tkn.Token, tkn.ID, 4)
+	assert.ErrorIs(err, nft.ErrRevisionNotFound)
+	_, err = m.Rollback(tkn.Token, tkn.ID, 0)
+	assert.ErrorIs(err, nft.ErrRevisionNotFound)
+
+	_, err = m.CompareAndRollback(nft.NFT{Token: tkn.Token, ID: tkn.ID}, 1)
+	assert.ErrorIs(err, nft.ErrConflict)
+
+	rolledBack, err := m.Rollback(tkn.Token, tkn.ID, 1)
+	assert.NoError(err)
+	assert.Equal("first", rolledBack.Title)
+	assert.Empty(rolledBack.Desc)
+	assert.Equal(tkn.Owner, rolledBack.Owner)
+	rev, err := m.Revision(tkn.Token, tkn.ID, 4)
+	assert.NoError(err)
+	assert.Equal("first", rev.Title)
+}
+
+func TestNFTMemoryCompareAndUpsert(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		rng    = ptest.Prng(t)
+		tkn    = test.NewRandomNFT(rng)
+		m      = nft.NewMemory()
+	)
+
+	assert.ErrorIs(m.CompareAndUpsert(tkn, tkn), nft.ErrConflict)
+	assert.NoError(m.Upsert(tkn))
+
+	old := tkn
+	tkn.Title = "new"
+	assert.NoError(m.CompareAndUpsert(old, tkn))
+	tkn2 := tkn
+	tkn2.Title = "newer"
+	assert.ErrorIs(m.CompareAndUpsert(old, tkn2), nft.ErrConflict)
+
+	get, err := m.Get(tkn.Token, tkn.ID)
+	assert.NoError(err)
+	assert.Equal(tkn, get)
+}
+
+func TestNFTMemoryCollections(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		rng    = ptest.Prng(t)
+		m      = nft.NewMemory()
+		token  = eth.NewRandomAddress(rng)
+	)
+
+	_, err := m.GetCollection(token)
+	assert.ErrorIs(err, nft.ErrCollectionNotFound)
+
+	coll := nft.Collection{Token: token, Name: "Nerds", Symbol: "NERD", LogoAssetID: 1}
+	assert.NoError(m.PutCollection(coll))
+	get, err := m.GetCollection(token)
+	assert.NoError(err)
+	assert.Equal(coll, get)
+
+	// PutCollection replaces all fields
+	coll = nft.Collection{Token: token, Name: "Nerds v2"}
+	assert.NoError(m.PutCollection(coll))
+	get, err = m.GetCollection(token)
+	assert.NoError(err)
+	assert.Equal(coll, get)
+}
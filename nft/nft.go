@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/perun-network/erdstall/eth"
@@ -13,13 +14,59 @@ import (
 	"github.com/perun-network/erdstall/value"
 )
 
-var ErrNotFound = errors.New("NFT not found")
+var (
+	ErrNotFound         = errors.New("NFT not found")
+	ErrRevisionNotFound = errors.New("NFT revision not found")
+	ErrConflict         = errors.New("NFT was modified concurrently")
+	// ErrInvalid is matched by all ValidationErrors.
+	ErrInvalid = errors.New("invalid NFT")
+)
+
+// Stable, machine-readable codes of the above errors, e.g., for API responses.
+const (
+	CodeNotFound         = "nft_not_found"
+	CodeRevisionNotFound = "revision_not_found"
+	CodeConflict         = "conflict"
+	CodeInvalid          = "invalid"
+)
+
+// ErrorCode returns the code of the error of this package that err matches,
+// or the empty string if it matches none.
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrRevisionNotFound):
+		return CodeRevisionNotFound
+	case errors.Is(err, ErrCollectionNotFound):
+		return CodeCollectionNotFound
+	case errors.Is(err, ErrConflict):
+		return CodeConflict
+	case errors.Is(err, ErrInvalid):
+		return CodeInvalid
+	}
+	return ""
+}
+
+const (
+	// StateOwned marks an NFT that is held by its Owner on Erdstall.
+	StateOwned State = "owned"
+	// StateWithdrawn marks an NFT that left its Owner's Erdstall account, either
+	// by a withdrawal or a transfer. If it shows up in another account, its
+	// Owner is updated and it is marked StateOwned again.
+	StateWithdrawn State = "withdrawn"
+)
 
 type (
+	// State is the lifecycle state of an NFT. The empty State is used for NFTs
+	// that have not been seen on Erdstall yet.
+	State string
+
 	NFT struct {
 		Token common.Address `json:"token"`
 		ID    *big.Int       `json:"id"`
 		Owner common.Address `json:"owner"`
+		State State          `json:"state,omitempty"`
 
 		// AssetID is the id of the asset in the assets storage. It must be
 		// positive. A value of 0 indicates no asset is set (yet).
@@ -28,6 +75,32 @@ type (
 		Secret bool   `json:"secret"`
 		Title  string `json:"title"`
 		Desc   string `json:"desc"`
+		// Attributes are the NFT's traits.
+		Attributes []Attribute `json:"attributes,omitempty"`
+		// Royalty, if set, overrides the royalty of the NFT's collection.
+		Royalty *Royalty `json:"royalty,omitempty"`
+	}
+
+	// OwnerChange records a change of an NFT's owner. From is the zero address
+	// for the first owner of an NFT.
+	OwnerChange struct {
+		From common.Address `json:"from"`
+		To   common.Address `json:"to"`
+		Time time.Time      `json:"time"`
+	}
+
+	// Revision is an immutable snapshot of the metadata of an NFT. A revision
+	// is recorded on every change of the metadata fields AssetID, Secret,
+	// Title, Desc or Attributes. Revisions are numbered consecutively, starting
+	// at 1.
+	Revision struct {
+		Rev        uint64      `json:"rev"`
+		Time       time.Time   `json:"time"`
+		AssetID    uint        `json:"assetId,omitempty"`
+		Secret     bool        `json:"secret"`
+		Title      string      `json:"title"`
+		Desc       string      `json:"desc"`
+		Attributes []Attribute `json:"attributes,omitempty"`
 	}
 
 	Storage interface {
@@ -36,23 +109,96 @@ type (
 		// exists iff an NFT with the same values for token and id already exists.
 		//
 		// Field Owner is updated if it is not the zero address.
+		// Field State is updated if it is not empty.
 		// Field AssetID is updated if it is > 0.
 		// Field Secret is update if it is true.
+		// Field Attributes is replaced if it is not nil.
+		// Field Royalty is updated if it is not nil.
 		Upsert(nft NFT) error
 
+		// CompareAndUpsert updates the stored NFT like Upsert, but only if it is
+		// equal to old, see NFT.Equal. If the NFT doesn't exist or differs from
+		// old, ErrConflict is returned.
+		CompareAndUpsert(old, nft NFT) error
+
+		// UpsertFields is like Upsert, but the metadata fields in mask are
+		// always set to their values in nft, also if these are zero values. This
+		// allows to clear fields, see NFT.UpdateFields.
+		UpsertFields(nft NFT, mask FieldMask) error
+
+		// CompareAndUpsertFields is like UpsertFields, but only updates the
+		// stored NFT if it is equal to old, like CompareAndUpsert.
+		CompareAndUpsertFields(old, nft NFT, mask FieldMask) error
+
 		// Get gets the NFT identified by token and id from the storage.
 		//
 		// If it is not found ErrNFTNotFound is returned.
 		Get(token common.Address, id *big.Int) (NFT, error)
 
+		// History returns the ownership history of the NFT identified by token
+		// and id, oldest change first. Every owner change is recorded on Upsert.
+		//
+		// If the NFT is not found ErrNotFound is returned.
+		History(token common.Address, id *big.Int) ([]OwnerChange, error)
+
+		// Revisions returns all metadata revisions of the NFT identified by token
+		// and id, oldest revision first.
+		//
+		// If the NFT is not found ErrNotFound is returned.
+		Revisions(token common.Address, id *big.Int) ([]Revision, error)
+
+		// Revision returns revision rev of the NFT identified by token and id.
+		//
+		// If the NFT is not found ErrNotFound is returned. If the revision is not
+		// found ErrRevisionNotFound is returned.
+		Revision(token common.Address, id *big.Int, rev uint64) (Revision, error)
+
+		// Rollback sets the metadata of the NFT identified by token and id to the
+		// metadata of revision rev, recording it as a new revision. It returns
+		// the updated NFT.
+		//
+		// If the NFT is not found ErrNotFound is returned. If the revision is not
+		// found ErrRevisionNotFound is returned.
+		Rollback(token common.Address, id *big.Int, rev uint64) (NFT, error)
+
+		// CompareAndRollback rolls back the NFT identified by old's token and
+		// id like Rollback, but only if it is equal to old, see NFT.Equal.
+		// Otherwise, ErrConflict is returned.
+		CompareAndRollback(old NFT, rev uint64) (NFT, error)
+
 		// GetAll returns all NFTs in this storage.
 		GetAll() ([]NFT, error)
+
+		// GetByOwner returns all NFTs currently owned by owner.
+		GetByOwner(owner common.Address) ([]NFT, error)
+
+		// GetByToken returns all NFTs of token contract token.
+		GetByToken(token common.Address) ([]NFT, error)
+
+		// GetByAsset returns all NFTs with asset id assetID.
+		GetByAsset(assetID uint) ([]NFT, error)
+
+		// Scan calls fn with the NFTs of owner q.Owner, if set, or else of
+		// token q.Token, if set, or else all NFTs, in the order of q, see
+		// Query, starting after q's cursor, until fn returns false. The other
+		// fields of q are ignored. fn must not call the storage.
+		Scan(q Query, fn func(NFT) bool) error
+
+		// PutCollection inserts the metadata of collection c.Token or replaces
+		// it if it already exists.
+		PutCollection(c Collection) error
+
+		// GetCollection gets the metadata of the collection of token contract
+		// token.
+		//
+		// If it is not found ErrCollectionNotFound is returned.
+		GetCollection(token common.Address) (Collection, error)
 	}
 )
 
 // Extract extracts all NFTs from Account `acc` belonging to `owner`.
-// The NFT fields Token, ID and Owner will be set whereas ImageID and Secret
-// will be set to the default value.
+// The NFT fields Token, ID and Owner will be set and State will be StateOwned
+// whereas AssetID and Secret will be set to the default value.
 func Extract(owner common.Address, acc tee.Account) (nfts []NFT) {
 	for token, val := range acc.Values {
 		ids, ok := val.(*value.IDSet)
@@ -66,15 +212,123 @@ func Extract(owner common.Address, acc tee.Account) (nfts []NFT) {
 				Token: token,
 				ID:    id,
 				Owner: owner,
+				State: StateOwned,
 			})
 		}
 	}
 	return
 }
 
+// Gone returns the NFTs in `stored` that are owned by `owner` in state
+// StateOwned but are not part of Account `acc` anymore. The returned NFTs only
+// have fields Token, ID and State set, the latter to StateWithdrawn, so that
+// they can be directly upserted.
+func Gone(owner common.Address, acc tee.Account, stored []NFT) (gone []NFT) {
+	held := make(map[string]struct{})
+	for _, nft := range Extract(owner, acc) {
+		held[key(nft.Token, nft.ID)] = struct{}{}
+	}
+	for _, nft := range stored {
+		if nft.Owner != owner || nft.State != StateOwned {
+			continue
+		}
+		if _, ok := held[key(nft.Token, nft.ID)]; !ok {
+			gone = append(gone, NFT{
+				Token: nft.Token,
+				ID:    nft.ID,
+				State: StateWithdrawn,
+			})
+		}
+	}
+	return
+}
+
+// key returns a string uniquely identifying the NFT (token, id).
+func key(token common.Address, id *big.Int) string {
+	return string(token.Bytes()) + string(id.Bytes())
+}
+
 func (t *NFT) String() string {
-	return fmt.Sprintf("NFT{Token: %s, ID: %s, Owner: %s, AssetID: %d, Secret: %t, Title: `%s`, Desc: `%s`}",
-		t.Token.String(), t.ID, t.Owner.String(), t.AssetID, t.Secret, t.Title, t.Desc)
+	return fmt.Sprintf("NFT{Token: %s, ID: %s, Owner: %s, State: %s, AssetID: %d, Secret: %t, Title: `%s`, Desc: `%s`, Attributes: %v, Royalty: %v}",
+		t.Token.String(), t.ID, t.Owner.String(), t.State, t.AssetID, t.Secret, t.Title, t.Desc, t.Attributes, t.Royalty)
+}
+
+// RedactedFor returns the view of NFT t for viewer. If t is secret and viewer
+// is not its owner, the private fields AssetID, Title, Desc and Attributes
+// are cleared. The zero address is never considered the owner.
+func (t NFT) RedactedFor(viewer common.Address) NFT {
+	if !t.Secret || (viewer != eth.Zero && viewer == t.Owner) {
+		return t
+	}
+	t.AssetID, t.Title, t.Desc, t.Attributes = 0, "", "", nil
+	return t
+}
+
+// Redacted returns revision r with the private fields AssetID, Title, Desc and
+// Attributes cleared if r is secret.
+func (r Revision) Redacted() Revision {
+	if r.Secret {
+		r.AssetID, r.Title, r.Desc, r.Attributes = 0, "", "", nil
+	}
+	return r
+}
+
+// revisionOf returns the metadata of nft as an unnumbered Revision.
+func revisionOf(nft *NFT) Revision {
+	return Revision{
+		AssetID:    nft.AssetID,
+		Secret:     nft.Secret,
+		Title:      nft.Title,
+		Desc:       nft.Desc,
+		Attributes: nft.Attributes,
+	}
+}
+
+// sameMetadata reports whether r and o hold the same metadata, disregarding
+// revision number and time.
+func (r Revision) sameMetadata(o Revision) bool {
+	return r.AssetID == o.AssetID && r.Secret == o.Secret &&
+		r.Title == o.Title && r.Desc == o.Desc && attributesEqual(r.Attributes, o.Attributes)
+}
+
+// Equal reports whether t and o have equal values in all fields.
+func (t NFT) Equal(o NFT) bool {
+	return t.Token == o.Token && ((t.ID == nil && o.ID == nil) ||
+		(t.ID != nil && o.ID != nil && t.ID.Cmp(o.ID) == 0)) &&
+		t.Owner == o.Owner && t.State == o.State && revisionOf(&t).sameMetadata(revisionOf(&o)) &&
+		((t.Royalty == nil && o.Royalty == nil) ||
+			(t.Royalty != nil && o.Royalty != nil && *t.Royalty == *o.Royalty))
+}
+
+// UpdateFields updates t like Update, but the metadata fields in mask are
+// always set to their values in source, also if these are zero values. This
+// allows to clear the title, description, asset, attributes or royalty and to
+// unset Secret. Unknown fields in mask are ignored, see FieldMask.Validate.
+func (t *NFT) UpdateFields(source NFT, mask FieldMask) {
+	t.Update(source)
+	for _, f := range mask {
+		switch f {
+		case FieldAssetID:
+			t.AssetID = source.AssetID
+		case FieldSecret:
+			t.Secret = source.Secret
+		case FieldTitle:
+			t.Title = source.Title
+		case FieldDesc:
+			t.Desc = source.Desc
+		case FieldAttributes:
+			t.Attributes = nil
+			if len(source.Attributes) > 0 {
+				t.Attributes = append(make([]Attribute, 0, len(source.Attributes)), source.Attributes...)
+			}
+		case FieldRoyalty:
+			t.Royalty = nil
+			if source.Royalty != nil {
+				r := *source.Royalty
+				t.Royalty = &r
+			}
+		}
+	}
 }
 
 func (t *NFT) Update(source NFT) {
@@ -86,6 +340,9 @@ func (t *NFT) Update(source NFT) {
 	if source.Owner != eth.Zero {
 		t.Owner = source.Owner
 	}
+	if source.State != "" {
+		t.State = source.State
+	}
 	if source.AssetID != 0 {
 		t.AssetID = source.AssetID
 	}
@@ -98,4 +355,11 @@ func (t *NFT) Update(source NFT) {
 	if source.Desc != "" {
 		t.Desc = source.Desc
 	}
+	if source.Attributes != nil {
+		t.Attributes = append(make([]Attribute, 0, len(source.Attributes)), source.Attributes...)
+	}
+	if source.Royalty != nil {
+		r := *source.Royalty
+		t.Royalty = &r
+	}
 }
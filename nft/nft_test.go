@@ -0,0 +1,32 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{nft.ErrNotFound, nft.CodeNotFound},
+		{nft.ErrRevisionNotFound, nft.CodeRevisionNotFound},
+		{nft.ErrCollectionNotFound, nft.CodeCollectionNotFound},
+		{nft.ErrConflict, nft.CodeConflict},
+		{nft.ErrInvalid, nft.CodeInvalid},
+		{nft.ValidationError{{Field: nft.FieldTitle, Message: "too long"}}, nft.CodeInvalid},
+	} {
+		assert.Equal(tc.code, nft.ErrorCode(tc.err), tc.err.Error())
+		assert.Equal(tc.code, nft.ErrorCode(fmt.Errorf("wrapped: %w", tc.err)), tc.err.Error())
+	}
+	assert.Empty(nft.ErrorCode(fmt.Errorf("unknown")))
+}
@@ -3,6 +3,7 @@
 package nft
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"math/big"
@@ -16,16 +17,21 @@ type jsonNFT struct {
 	Token   *common.Address `json:"token"`
 	ID      string          `json:"id"`
 	Owner   *common.Address `json:"owner"`
+	State   *State          `json:"state,omitempty"`
 	AssetID *uint           `json:"assetId,omitempty"`
 	Secret  *bool           `json:"secret"`
 	Title   *string         `json:"title"`
 	Desc    *string         `json:"desc"`
+	// Attributes is omitted if empty, so that the encoding of NFTs without
+	// attributes stays the same.
+	Attributes *[]Attribute `json:"attributes,omitempty"`
+	Royalty    **Royalty    `json:"royalty,omitempty"`
 }
 
 const idBase = 10
 
 func (t NFT) MarshalJSON() ([]byte, error) {
-	return json.Marshal(jsonNFT{
+	jt := jsonNFT{
 		Token:   &t.Token,
 		ID:      t.ID.Text(idBase),
 		Owner:   &t.Owner,
@@ -33,17 +39,43 @@ func (t NFT) MarshalJSON() ([]byte, error) {
 		Secret:  &t.Secret,
 		Title:   &t.Title,
 		Desc:    &t.Desc,
-	})
+	}
+	if t.State != "" {
+		jt.State = &t.State
+	}
+	if len(t.Attributes) > 0 {
+		jt.Attributes = &t.Attributes
+	}
+	if t.Royalty != nil {
+		jt.Royalty = &t.Royalty
+	}
+	return marshalJSON(jt)
+}
+
+// marshalJSON is like json.Marshal, but doesn't escape the HTML characters <,
+// > and &, like JavaScript's JSON.stringify. So clients can sign the encoding
+// of an NFT, see nftserv.AuthMessage.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
 }
 
 func (t *NFT) UnmarshalJSON(data []byte) error {
 	jt := jsonNFT{
-		Token:   &t.Token,
-		Owner:   &t.Owner,
-		AssetID: &t.AssetID,
-		Secret:  &t.Secret,
-		Title:   &t.Title,
-		Desc:    &t.Desc,
+		Token:      &t.Token,
+		Owner:      &t.Owner,
+		State:      &t.State,
+		AssetID:    &t.AssetID,
+		Secret:     &t.Secret,
+		Title:      &t.Title,
+		Desc:       &t.Desc,
+		Attributes: &t.Attributes,
+		Royalty:    &t.Royalty,
 	}
 	if err := json.Unmarshal(data, &jt); err != nil {
 		return fmt.Errorf("unmarshalling into jsonNFT: %w", err)
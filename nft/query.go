@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Query selects a page of NFTs from a Storage. NFTs are ordered by token and
+// then by id, which gives a stable order for paging through the results.
+type Query struct {
+	// Owner and Token, if not nil, restrict the result to NFTs with the given
+	// owner or token.
+	Owner, Token *common.Address
+	// Secret and HasAsset, if not nil, restrict the result to NFTs with the
+	// given secrecy or to NFTs with or without an asset.
+	Secret, HasAsset *bool
+	// Traits, if not empty, restricts the result to NFTs having all of the
+	// given traits.
+	Traits []Trait
+	// Redact, if set, redacts all NFTs for Viewer before they are filtered and
+	// returned, see NFT.RedactedFor.
+	Redact bool
+	Viewer common.Address
+	// Desc reverses the order.
+	Desc bool
+	// Limit is the maximal number of returned NFTs. 0 means no limit.
+	Limit int
+	// AfterToken and AfterID, if AfterID is not nil, make the result start
+	// right after NFT (AfterToken, AfterID) in the requested order.
+	AfterToken common.Address
+	AfterID    *big.Int
+}
+
+// Trait selects NFTs having an attribute of trait type Type with value Value,
+// see HasTrait.
+type Trait struct {
+	Type, Value string
+}
+
+// Select runs query q on storage s. It returns the selected NFTs and whether
+// more NFTs follow after the last returned one. Only the NFTs up to the end of
+// the page are visited, see Storage.Scan.
+func Select(s Storage, q Query) (sel []NFT, more bool, err error) {
+	err = s.Scan(q, func(nft NFT) bool {
+		if q.Redact {
+			nft = nft.RedactedFor(q.Viewer)
+		}
+		if !q.matches(nft) {
+			return true
+		} else if q.Limit > 0 && len(sel) == q.Limit {
+			more = true
+			return false
+		}
+		sel = append(sel, nft)
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return sel, more, nil
+}
+
+func (q *Query) matches(nft NFT) bool {
+	return (q.Owner == nil || nft.Owner == *q.Owner) &&
+		(q.Token == nil || nft.Token == *q.Token) &&
+		(q.Secret == nil || nft.Secret == *q.Secret) &&
+		(q.HasAsset == nil || (nft.AssetID != 0) == *q.HasAsset) &&
+		q.hasTraits(nft)
+}
+
+func (q *Query) hasTraits(nft NFT) bool {
+	for _, t := range q.Traits {
+		if !HasTrait(nft.Attributes, t.Type, t.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare compares the NFTs identified by (tokenA, idA) and (tokenB, idB),
+// first by token and then by id. The result is -1, 0 or +1.
+func Compare(tokenA common.Address, idA *big.Int, tokenB common.Address, idB *big.Int) int {
+	if c := bytes.Compare(tokenA.Bytes(), tokenB.Bytes()); c != 0 {
+		return c
+	}
+	return idA.Cmp(idB)
+}
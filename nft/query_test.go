@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/erdstall/eth"
+
+	"github.com/perun-network/nerd-op/nft"
+	"github.com/perun-network/nerd-op/nft/test"
+)
+
+func TestSelect(t *testing.T) {
+	var (
+		rng   = ptest.Prng(t)
+		m     = nft.NewMemory()
+		owner = eth.NewRandomAddress(rng)
+		n     = 20
+	)
+
+	for i := 0; i < n; i++ {
+		tkn := test.NewRandomNFT(rng)
+		if i%2 == 0 {
+			tkn.Owner = owner
+		}
+		require.NoError(t, m.Upsert(tkn))
+	}
+
+	all, more, err := nft.Select(m, nft.Query{})
+	require.NoError(t, err)
+	require.False(t, more)
+	require.Len(t, all, n)
+	for i := 1; i < len(all); i++ {
+		require.Negative(t, nft.Compare(all[i-1].Token, all[i-1].ID, all[i].Token, all[i].ID))
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		require := require.New(t)
+		owned, _, err := nft.Select(m, nft.Query{Owner: &owner})
+		require.NoError(err)
+		require.Len(owned, n/2)
+		for _, tkn := range owned {
+			require.Equal(owner, tkn.Owner)
+		}
+
+		secret := true
+		secrets, _, err := nft.Select(m, nft.Query{Secret: &secret})
+		require.NoError(err)
+		for _, tkn := range secrets {
+			require.True(tkn.Secret)
+		}
+
+		token := all[3].Token
+		byToken, _, err := nft.Select(m, nft.Query{Token: &token})
+		require.NoError(err)
+		require.Equal([]nft.NFT{all[3]}, byToken)
+
+		tm := nft.NewMemory()
+		traitTkn, otherTkn := test.NewRandomNFT(rng), test.NewRandomNFT(rng)
+		traitTkn.Attributes = []nft.Attribute{
+			{TraitType: "Color", Value: "Blue"},
+			{TraitType: "Level", Value: 5.0},
+		}
+		otherTkn.Attributes = traitTkn.Attributes[:1]
+		require.NoError(tm.Upsert(traitTkn))
+		require.NoError(tm.Upsert(otherTkn))
+		withTraits, _, err := nft.Select(tm, nft.Query{Traits: []nft.Trait{
+			{Type: "Color", Value: "Blue"},
+			{Type: "Level", Value: "5"},
+		}})
+		require.NoError(err)
+		require.Equal([]nft.NFT{traitTkn}, withTraits)
+		none, _, err := nft.Select(tm, nft.Query{Traits: []nft.Trait{{Type: "Color", Value: "Red"}}})
+		require.NoError(err)
+		require.Empty(none)
+	})
+
+	t.Run("index", func(t *testing.T) {
+		require := require.New(t)
+		tm := nft.NewMemory()
+		token := eth.NewRandomAddress(rng)
+		tkns := make([]nft.NFT, 5)
+		for i := range tkns {
+			tkns[i] = test.NewRandomNFT(rng)
+			tkns[i].Token, tkns[i].ID, tkns[i].Owner = token, big.NewInt(int64(i)), owner
+			require.NoError(tm.Upsert(tkns[i]))
+		}
+		// transfers keep the owner index ordered
+		newOwner := eth.NewRandomAddress(rng)
+		for _, i := range []int{3, 1} {
+			tkns[i].Owner = newOwner
+			require.NoError(tm.Upsert(tkns[i]))
+		}
+		owned, _, err := nft.Select(tm, nft.Query{Owner: &newOwner})
+		require.NoError(err)
+		require.Equal([]nft.NFT{tkns[1], tkns[3]}, owned)
+
+		page, more, err := nft.Select(tm, nft.Query{
+			Owner: &owner, Desc: true, Limit: 1,
+			AfterToken: token, AfterID: big.NewInt(4),
+		})
+		require.NoError(err)
+		require.True(more)
+		require.Equal([]nft.NFT{tkns[2]}, page)
+		page, more, err = nft.Select(tm, nft.Query{Token: &token, AfterToken: token, AfterID: big.NewInt(2)})
+		require.NoError(err)
+		require.False(more)
+		require.Equal(tkns[3:], page)
+	})
+
+	for _, desc := range []bool{false, true} {
+		q := nft.Query{Desc: desc, Limit: 3}
+		var paged []nft.NFT
+		for {
+			page, more, err := nft.Select(m, q)
+			require.NoError(t, err)
+			paged = append(paged, page...)
+			if !more {
+				break
+			}
+			last := page[len(page)-1]
+			q.AfterToken, q.AfterID = last.Token, last.ID
+		}
+		require.Len(t, paged, n)
+		for i := range paged {
+			if desc {
+				require.Equal(t, all[n-1-i], paged[i])
+			} else {
+				require.Equal(t, all[i], paged[i])
+			}
+		}
+	}
+}
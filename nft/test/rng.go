@@ -1,6 +1,7 @@
 package test
 
 import (
+	"fmt"
 	"math/big"
 	"math/rand"
 
@@ -16,5 +17,23 @@ func NewRandomNFT(rng *rand.Rand) nft.NFT {
 		Owner:   eth.NewRandomAddress(rng),
 		AssetID: uint(rng.Uint32()),
 		Secret:  rng.Intn(2) == 1,
+
+		Attributes: NewRandomAttributes(rng),
+	}
+}
+
+// NewRandomAttributes returns up to three random attributes with unique trait
+// types, or nil.
+func NewRandomAttributes(rng *rand.Rand) (attrs []nft.Attribute) {
+	for i := rng.Intn(4); i > 0; i-- {
+		a := nft.Attribute{TraitType: fmt.Sprintf("trait%d", i)}
+		if rng.Intn(2) == 0 {
+			a.Value = fmt.Sprintf("value%d", rng.Intn(10))
+		} else {
+			max := float64(rng.Intn(100))
+			a.Value, a.DisplayType, a.MaxValue = float64(rng.Intn(int(max)+1)), nft.DisplayNumber, &max
+		}
+		attrs = append(attrs, a)
 	}
+	return
 }
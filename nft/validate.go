@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxTitleLen is the maximal length of an NFT's title in characters.
+	MaxTitleLen = 256
+	// MaxDescLen is the maximal length of an NFT's description in characters.
+	MaxDescLen = 4096
+)
+
+type (
+	// FieldError describes why a field of an NFT is invalid. Field is the
+	// field's JSON key.
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	// ValidationError holds all field errors of an invalid NFT.
+	ValidationError []FieldError
+
+	// AssetExistsFunc reports whether the asset with the given id exists.
+	AssetExistsFunc func(id uint) (bool, error)
+)
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Is reports whether target is ErrInvalid.
+func (e ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return "invalid NFT: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the metadata of t. The title and description must be valid
+// UTF-8 of at most MaxTitleLen and MaxDescLen characters without control
+// characters, except for line breaks and tabs in the description. The
+// attributes must be valid, see ValidateAttributes, and the asset, if set,
+// must exist according to assetExists, which may be nil to skip this check.
+//
+// All invalid fields are returned as a ValidationError. Other errors are
+// returned if assetExists fails.
+func (t NFT) Validate(assetExists AssetExistsFunc) error {
+	var verr ValidationError
+	if msg := validateText(t.Title, MaxTitleLen, false); msg != "" {
+		verr = append(verr, FieldError{Field: FieldTitle, Message: msg})
+	}
+	if msg := validateText(t.Desc, MaxDescLen, true); msg != "" {
+		verr = append(verr, FieldError{Field: FieldDesc, Message: msg})
+	}
+	if err := ValidateAttributes(t.Attributes); err != nil {
+		verr = append(verr, FieldError{Field: FieldAttributes, Message: err.Error()})
+	}
+	if t.AssetID != 0 && assetExists != nil {
+		ok, err := assetExists(t.AssetID)
+		if err != nil {
+			return fmt.Errorf("checking asset %d: %w", t.AssetID, err)
+		} else if !ok {
+			verr = append(verr, FieldError{Field: FieldAssetID, Message: fmt.Sprintf("asset %d doesn't exist", t.AssetID)})
+		}
+	}
+
+	if len(verr) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validateText returns why s is not a valid text of at most maxLen
+// characters, or the empty string if it is valid. If multiline is set, line
+// breaks and tabs are allowed.
+func validateText(s string, maxLen int, multiline bool) string {
+	if !utf8.ValidString(s) {
+		return "invalid UTF-8"
+	} else if n := utf8.RuneCountInString(s); n > maxLen {
+		return fmt.Sprintf("%d characters exceed maximum of %d", n, maxLen)
+	}
+	for _, r := range s {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			continue
+		} else if unicode.IsControl(r) {
+			return fmt.Sprintf("forbidden control character %U", r)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nft_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ptest "perun.network/go-perun/pkg/test"
+
+	"github.com/perun-network/nerd-op/nft"
+	"github.com/perun-network/nerd-op/nft/test"
+)
+
+func TestValidate(t *testing.T) {
+	var (
+		rng    = ptest.Prng(t)
+		tkn    = test.NewRandomNFT(rng)
+		exists = func(id uint) (bool, error) { return id == 1, nil }
+	)
+	tkn.AssetID, tkn.Title, tkn.Desc = 1, "Nerd #1", "A nerd.\n\tIt likes ümlauts."
+
+	require.NoError(t, tkn.Validate(exists))
+	require.NoError(t, tkn.Validate(nil))
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*nft.NFT)
+		field  string
+	}{
+		{"long-title", func(n *nft.NFT) { n.Title = strings.Repeat("ä", nft.MaxTitleLen+1) }, nft.FieldTitle},
+		{"newline-title", func(n *nft.NFT) { n.Title = "Nerd\n#1" }, nft.FieldTitle},
+		{"utf8-title", func(n *nft.NFT) { n.Title = "Nerd \xff" }, nft.FieldTitle},
+		{"long-desc", func(n *nft.NFT) { n.Desc = strings.Repeat("a", nft.MaxDescLen+1) }, nft.FieldDesc},
+		{"control-desc", func(n *nft.NFT) { n.Desc = "bell\a" }, nft.FieldDesc},
+		{"c1-desc", func(n *nft.NFT) { n.Desc = "\u0085" }, nft.FieldDesc},
+		{"attributes", func(n *nft.NFT) { n.Attributes = []nft.Attribute{{Value: true}} }, nft.FieldAttributes},
+		{"asset", func(n *nft.NFT) { n.AssetID = 2 }, nft.FieldAssetID},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			invalid := tkn
+			tc.modify(&invalid)
+			var verr nft.ValidationError
+			require.ErrorAs(t, invalid.Validate(exists), &verr)
+			require.Len(t, verr, 1)
+			assert.Equal(t, tc.field, verr[0].Field)
+		})
+	}
+
+	t.Run("all", func(t *testing.T) {
+		invalid := tkn
+		invalid.AssetID, invalid.Title, invalid.Desc = 2, "\x00", "\x00"
+		var verr nft.ValidationError
+		require.ErrorAs(t, invalid.Validate(exists), &verr)
+		assert.Len(t, verr, 3)
+		// the asset isn't checked without asset storage
+		require.ErrorAs(t, invalid.Validate(nil), &verr)
+		assert.Len(t, verr, 2)
+	})
+
+	t.Run("asset-error", func(t *testing.T) {
+		storageErr := errors.New("storage error")
+		err := tkn.Validate(func(uint) (bool, error) { return false, storageErr })
+		assert.ErrorIs(t, err, storageErr)
+		assert.False(t, errors.As(err, new(nft.ValidationError)))
+	})
+}
@@ -0,0 +1,356 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/perun-network/erdstall/eth"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+const (
+	// NonceHeader is the request header holding the nonce of a signed request.
+	NonceHeader = "X-Nonce"
+	// SignatureHeader is the request header holding the signature of a signed
+	// request.
+	SignatureHeader = "X-Signature"
+	// SignatureSchemeHeader is the request header selecting the signature
+	// scheme, either SchemePersonal (default) or SchemeTypedData.
+	SignatureSchemeHeader = "X-Signature-Scheme"
+	// DeadlineHeader is the request header holding the deadline of an EIP-712
+	// signature as unix timestamp in seconds.
+	DeadlineHeader = "X-Deadline"
+
+	// SchemePersonal selects personal_sign (EIP-191) signatures on the
+	// AuthMessage.
+	SchemePersonal = "eip191"
+	// SchemeTypedData selects EIP-712 signatures on the NFTUpdate. It is only
+	// supported for ActionUpdate.
+	SchemeTypedData = "eip712"
+
+	// NonceTTL is the time after which an unused nonce expires.
+	NonceTTL = 5 * time.Minute
+	nonceLen = 16
+	// nonceMACLen is the length of the truncated HMAC-SHA256 of a nonce.
+	nonceMACLen = 16
+)
+
+// Actions that require authentication.
+const (
+	ActionUpdate   = "update"
+	ActionPatch    = "patch"
+	ActionRollback = "rollback"
+	// ActionRead is used to authenticate the viewer of secret NFTs.
+	ActionRead = "read"
+	// ActionUpload is used to authenticate the uploader of assets.
+	ActionUpload = "upload"
+	// ActionCollection and ActionRoyalty are used to authenticate the
+	// collection admin.
+	ActionCollection = "collection"
+	ActionRoyalty    = "royalty"
+)
+
+var (
+	errNoSignature = errors.New("request not signed")
+	// errNotAuthorized is returned by authenticate if the caller is not
+	// authorized.
+	errNotAuthorized = errors.New("not authorized")
+)
+
+type (
+	// nonceStore issues single-use nonces that expire after NonceTTL. A nonce
+	// is the hex encoding of random bytes and the big-endian unix expiry
+	// time, followed by their truncated HMAC-SHA256. So issuing nonces doesn't
+	// take any memory. Only consumed nonces are remembered until they expire.
+	nonceStore struct {
+		key []byte
+
+		mu   sync.Mutex
+		used map[string]struct{}
+		// queue holds the used nonces in the order of their removal, which
+		// is NonceTTL after they were consumed, and so after they expired.
+		queue []usedNonce
+	}
+
+	usedNonce struct {
+		nonce  string
+		remove time.Time
+	}
+
+	nonceResponse struct {
+		Nonce string `json:"nonce"`
+	}
+
+	// pathPayload is the payload of the AuthMessage of a read or an upload.
+	// Path is the request's URL path.
+	pathPayload struct {
+		Path string `json:"path"`
+	}
+
+	// rollbackPayload is the payload of the AuthMessage of a rollback.
+	rollbackPayload struct {
+		Token common.Address `json:"token"`
+		ID    string         `json:"id"`
+		Rev   uint64         `json:"rev"`
+	}
+)
+
+// newNonceStore returns a nonceStore with a random HMAC key, so nonces don't
+// survive restarts.
+func newNonceStore() (*nonceStore, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("reading randomness: %w", err)
+	}
+	return &nonceStore{key: key, used: make(map[string]struct{})}, nil
+}
+
+// Issue returns a fresh random nonce.
+func (ns *nonceStore) Issue() (string, error) {
+	payload := make([]byte, nonceLen+8)
+	if _, err := rand.Read(payload[:nonceLen]); err != nil {
+		return "", fmt.Errorf("reading randomness: %w", err)
+	}
+	binary.BigEndian.PutUint64(payload[nonceLen:], uint64(time.Now().Add(NonceTTL).Unix()))
+	return hex.EncodeToString(append(payload, ns.mac(payload)...)), nil
+}
+
+// Valid reports whether nonce was issued by this store and is not expired. It
+// doesn't check whether nonce was already consumed.
+func (ns *nonceStore) Valid(nonce string) bool {
+	data, err := hex.DecodeString(nonce)
+	if err != nil || len(data) != nonceLen+8+nonceMACLen {
+		return false
+	}
+	payload, mac := data[:nonceLen+8], data[nonceLen+8:]
+	exp := time.Unix(int64(binary.BigEndian.Uint64(payload[nonceLen:])), 0)
+	return hmac.Equal(mac, ns.mac(payload)) && time.Now().Before(exp)
+}
+
+// Consume reports whether nonce is valid, see Valid, and was not consumed
+// before. A nonce can only be consumed once.
+func (ns *nonceStore) Consume(nonce string) bool {
+	if !ns.Valid(nonce) {
+		return false
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	now := time.Now()
+	for len(ns.queue) > 0 && now.After(ns.queue[0].remove) {
+		delete(ns.used, ns.queue[0].nonce)
+		ns.queue = ns.queue[1:]
+	}
+	if _, ok := ns.used[nonce]; ok {
+		return false
+	}
+	ns.used[nonce] = struct{}{}
+	ns.queue = append(ns.queue, usedNonce{nonce: nonce, remove: now.Add(NonceTTL)})
+	return true
+}
+
+func (ns *nonceStore) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, ns.key)
+	h.Write(payload)
+	return h.Sum(nil)[:nonceMACLen]
+}
+
+func (s *Server) handleGETnonce(w http.ResponseWriter, r *http.Request) {
+	nonce, err := s.nonces.Issue()
+	if err != nil {
+		httpError(w, "Error issuing nonce: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(nonceResponse{Nonce: nonce}); err != nil {
+		log.Errorf("Error JSON-marshalling nonce: %v", err)
+	}
+}
+
+// AuthMessage returns the message that has to be signed with personal_sign
+// (EIP-191) to authorize the action with the given payload. payload is JSON
+// encoded without any whitespace and without escaping HTML characters, like
+// JavaScript's JSON.stringify does, and nonce is a nonce from GET /auth/nonce.
+//
+// The message has the form "NERD {action}\n{payload}\nNonce: {nonce}".
+func AuthMessage(action string, payload interface{}, nonce string) ([]byte, error) {
+	data, err := compactJSON(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding payload: %w", err)
+	}
+	return []byte(fmt.Sprintf("NERD %s\n%s\nNonce: %s", action, data, nonce)), nil
+}
+
+// compactJSON returns the compact JSON encoding of v. Unlike json.Marshal, it
+// doesn't escape <, > and &, so that the encoding matches what clients sign.
+func compactJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+// personalHash returns the EIP-191 personal_sign hash of msg.
+func personalHash(msg []byte) []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)))
+}
+
+// recoverSigner recovers the signer of signature sig on hash. The recovery id
+// of sig may be 0/1 or 27/28.
+func recoverSigner(hash, sig []byte) (common.Address, error) {
+	if len(sig) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("invalid signature length %d", len(sig))
+	}
+	sig = append([]byte(nil), sig...)
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// authenticate returns the verified address of the caller of request r for
+// the given action and payload. If the request has a session, see
+// SessionMiddleware, the session address is returned. Otherwise, the
+// request's signature is verified and the signer is returned.
+//
+// If authorized is not nil, it must accept the caller. Otherwise,
+// errNotAuthorized is returned together with the caller. The request's nonce
+// is only consumed after the signature was verified and the caller
+// authorized, so that nobody else can use up the nonce.
+//
+// Depending on the request's signature scheme, the signature is either a
+// personal_sign signature on the AuthMessage or, for ActionUpdate, an EIP-712
+// signature on the NFTUpdate, in which case the payload must be an nft.NFT.
+func (s *Server) authenticate(r *http.Request, action string, payload interface{}, authorized func(common.Address) bool) (common.Address, error) {
+	if addr, ok := sessionAddress(r); ok {
+		if authorized != nil && !authorized(addr) {
+			return addr, errNotAuthorized
+		}
+		return addr, nil
+	}
+	nonce, sigHex := r.Header.Get(NonceHeader), r.Header.Get(SignatureHeader)
+	if nonce == "" || sigHex == "" {
+		return common.Address{}, errNoSignature
+	}
+	sig, err := hexutil.Decode(sigHex)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("decoding signature: %w", err)
+	}
+	if !s.nonces.Valid(nonce) {
+		return common.Address{}, errors.New("unknown or expired nonce")
+	}
+	hash, err := s.signedHash(r, action, payload, nonce)
+	if err != nil {
+		return common.Address{}, err
+	}
+	signer, err := recoverSigner(hash, sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("recovering signer: %w", err)
+	}
+	if authorized != nil && !authorized(signer) {
+		return signer, errNotAuthorized
+	}
+	if !s.nonces.Consume(nonce) {
+		return common.Address{}, errors.New("reused nonce")
+	}
+	return signer, nil
+}
+
+// signedHash returns the hash that must be signed for the given action,
+// payload and nonce, according to the signature scheme of request r.
+func (s *Server) signedHash(r *http.Request, action string, payload interface{}, nonce string) ([]byte, error) {
+	switch scheme := r.Header.Get(SignatureSchemeHeader); scheme {
+	case "", SchemePersonal:
+		msg, err := AuthMessage(action, payload, nonce)
+		if err != nil {
+			return nil, err
+		}
+		return personalHash(msg), nil
+	case SchemeTypedData:
+		tkn, ok := payload.(nft.NFT)
+		if action != ActionUpdate || !ok {
+			return nil, fmt.Errorf("signature scheme %s not supported for action %s", scheme, action)
+		}
+		deadline, err := strconv.ParseUint(r.Header.Get(DeadlineHeader), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deadline: %w", err)
+		} else if time.Now().Unix() > int64(deadline) {
+			return nil, errors.New("signature deadline passed")
+		}
+		upd, err := NewNFTUpdate(tkn, nonce, deadline)
+		if err != nil {
+			return nil, err
+		}
+		return upd.TypedDataHash(s.chainID()), nil
+	default:
+		return nil, fmt.Errorf("unknown signature scheme %q", scheme)
+	}
+}
+
+// authorizeOwner authenticates request r for the given action and payload and
+// checks that the signer is owner, the owner of the NFT to act upon. Otherwise,
+// it responds with an error and returns false.
+func (s *Server) authorizeOwner(w http.ResponseWriter, r *http.Request, owner common.Address, action string, payload interface{}) bool {
+	signer, err := s.authenticate(r, action, payload, func(signer common.Address) bool {
+		return owner != eth.Zero && signer == owner
+	})
+	if errors.Is(err, errNotAuthorized) {
+		if owner == eth.Zero {
+			httpError(w, "Token has no owner yet", http.StatusForbidden)
+		} else {
+			httpError(w, fmt.Sprintf("Signer %v is not the token owner", signer), http.StatusForbidden)
+		}
+		return false
+	} else if err != nil {
+		httpError(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// viewer returns the authenticated viewer of read request r. If the request is
+// not signed, the zero address is returned. If the signature is invalid, it
+// responds with an error and returns false.
+func (s *Server) viewer(w http.ResponseWriter, r *http.Request) (common.Address, bool) {
+	viewer, err := s.authenticate(r, ActionRead, pathPayload{Path: r.URL.Path}, nil)
+	if errors.Is(err, errNoSignature) {
+		return eth.Zero, true
+	} else if err != nil {
+		httpError(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return eth.Zero, false
+	}
+	return viewer, true
+}
+
+// setPrivate marks the response to a read request as depending on the
+// request's authentication, so that caches neither share it between viewers
+// nor reuse it for requests with other credentials.
+func setPrivate(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "private")
+	w.Header().Set("Vary", SignatureHeader+", Authorization")
+}
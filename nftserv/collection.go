@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/perun-network/erdstall/eth"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+// handleGETcollection returns the metadata of the collection of the token
+// contract.
+func (s *Server) handleGETcollection(w http.ResponseWriter, r *http.Request) {
+	token := mustReadToken(r)
+	coll, err := s.nfts.GetCollection(token)
+	if err != nil {
+		storageError(w, "", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(coll); err != nil {
+		log.Errorf("Error JSON-marshalling collection %v: %v", token, err)
+	}
+}
+
+// handlePUTcollection sets the metadata of the collection of the token
+// contract, replacing all fields. The request must be signed by the
+// configured collection admin.
+func (s *Server) handlePUTcollection(w http.ResponseWriter, r *http.Request) {
+	if !s.limitPayload(w, r) {
+		return
+	}
+	var coll nft.Collection
+	if err := json.NewDecoder(r.Body).Decode(&coll); err != nil {
+		payloadError(w, "Error decoding collection from payload: ", err)
+		return
+	}
+	if token := mustReadToken(r); coll.Token != token {
+		httpError(w, "Token mismatch between payload and URL", http.StatusBadRequest)
+		return
+	} else if err := coll.Validate(); err != nil {
+		httpError(w, "Invalid collection: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !s.authorizeAdmin(w, r, ActionCollection, coll) {
+		return
+	}
+
+	if err := s.nfts.PutCollection(coll); err != nil {
+		httpError(w, "Error updating collection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(coll); err != nil {
+		log.Errorf("Error JSON-marshalling collection %v: %v", coll.Token, err)
+	}
+}
+
+// authorizeAdmin authenticates request r for the given action and payload and
+// checks that the signer is the collection admin. Otherwise, it responds with
+// an error and returns false.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request, action string, payload interface{}) bool {
+	admin := s.cfg.CollectionAdmin
+	signer, err := s.authenticate(r, action, payload, func(signer common.Address) bool {
+		return admin != eth.Zero && signer == admin
+	})
+	if errors.Is(err, errNotAuthorized) {
+		if admin == eth.Zero {
+			httpError(w, "No collection admin configured", http.StatusForbidden)
+		} else {
+			httpError(w, fmt.Sprintf("Signer %v is not the collection admin", signer), http.StatusForbidden)
+		}
+		return false
+	} else if err != nil {
+		httpError(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -5,20 +5,56 @@ package nftserv
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Defaults of the HTTP server timeouts if none are configured.
+const (
+	DefaultShutdownTimeout   = 10 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 2 * time.Minute
 )
 
-const defaultWhitelistedOrigin = "*"
+// Defaults of the maximal sizes in bytes of request payloads and uploaded
+// assets if none are configured.
+const (
+	DefaultMaxPayloadSize = 64 << 10
+	DefaultMaxAssetSize   = 32 << 20
+)
+
+const (
+	defaultWhitelistedOrigin = "*"
+
+	StorageTypeMemory  = "memory"
+	StorageTypeJournal = "journal"
+)
 
 type (
 	Config struct {
-		Assets AssetsConfig `json:"assets"`
-		Server ServerConfig `json:"server"`
+		Assets  AssetsConfig  `json:"assets"`
+		Storage StorageConfig `json:"storage"`
+		Server  ServerConfig  `json:"server"`
 	}
 
 	AssetsConfig struct {
 		Path string `json:"path"`
-		Ext  string `json:"ext"`
+	}
+
+	// StorageConfig configures the NFT metadata storage.
+	StorageConfig struct {
+		// Type is either "memory" (default) or "journal".
+		Type string `json:"type"`
+		// Path is the journal file path. Only used by type "journal".
+		Path string `json:"path"`
+		// CompactThreshold is the minimal number of journal records before the
+		// journal is compacted. Only used by type "journal".
+		CompactThreshold int `json:"compactThreshold"`
 	}
 
 	ServerConfig struct {
@@ -27,7 +63,60 @@ type (
 		CertFile          string `json:"certFile"`
 		KeyFile           string `json:"keyFile"`
 		WhitelistedOrigin string `json:"whitelistedOrigin"`
-		MaxPayloadSize    int    `json:"maxPayloadSize"`
+		// MaxPayloadSize is the maximal size in bytes of request payloads,
+		// except asset uploads. 0 means no limit, but ReadConfig replaces 0 by
+		// DefaultMaxPayloadSize.
+		MaxPayloadSize int `json:"maxPayloadSize"`
+		// MaxAssetSize is the maximal size in bytes of uploaded assets. 0 means
+		// no limit, but ReadConfig replaces 0 by DefaultMaxAssetSize.
+		MaxAssetSize int64 `json:"maxAssetSize"`
+		// PublicURL is the base URL under which the server is publicly
+		// reachable, e.g., "https://nft.example.com". It is used for absolute
+		// URLs in ERC-721 metadata. If empty, the request's host is used.
+		PublicURL string `json:"publicUrl"`
+		// ExternalURL is the base URL of NFT pages on an external site. If set,
+		// ERC-721 metadata links to "{ExternalURL}/{token}/{id}".
+		ExternalURL string `json:"externalUrl"`
+		// CollectionAdmin is the address allowed to set collection metadata. If
+		// it is the zero address, collection metadata cannot be set.
+		CollectionAdmin common.Address `json:"collectionAdmin"`
+		// ChainID is the chain id used in the EIP-712 signing domain and expected
+		// in Sign-In with Ethereum messages.
+		ChainID uint64 `json:"chainId"`
+		// SIWEDomain is the domain expected in Sign-In with Ethereum messages. If
+		// empty, ReadConfig sets it to the host of PublicURL. Sign-In with
+		// Ethereum is disabled if it is empty.
+		SIWEDomain string `json:"siweDomain"`
+		// SessionSecret is the HMAC key for session tokens. If empty, a random
+		// key is used and sessions are lost on restart.
+		SessionSecret hexutil.Bytes `json:"sessionSecret"`
+		// SessionTTL is the session lifetime in seconds. If 0, DefaultSessionTTL
+		// is used.
+		SessionTTL uint `json:"sessionTTL"`
+		// ShutdownTimeout is the time in seconds that in-flight requests are
+		// given to finish on shutdown. If 0, DefaultShutdownTimeout is used.
+		ShutdownTimeout uint `json:"shutdownTimeout"`
+		// ReadTimeout and WriteTimeout are the maximal times in seconds for
+		// reading a whole request and writing a response, see http.Server. 0
+		// means no limit. Note that they also limit asset up- and downloads.
+		ReadTimeout  uint `json:"readTimeout"`
+		WriteTimeout uint `json:"writeTimeout"`
+		// ReadHeaderTimeout is the maximal time in seconds for reading the
+		// request headers. If 0, DefaultReadHeaderTimeout is used.
+		ReadHeaderTimeout uint `json:"readHeaderTimeout"`
+		// IdleTimeout is the maximal time in seconds that idle keep-alive
+		// connections are kept open. If 0, DefaultIdleTimeout is used.
+		IdleTimeout uint `json:"idleTimeout"`
+		// MaxHeaderBytes is the maximal size of the request headers. If 0,
+		// http.DefaultMaxHeaderBytes is used.
+		MaxHeaderBytes int `json:"maxHeaderBytes"`
+		// MaxConns is the maximal number of simultaneously open connections.
+		// Further connections wait until others are closed. 0 means no limit.
+		MaxConns int `json:"maxConns"`
+		// EventLogSize is the number of retained NFT change events that
+		// clients can resume from on GET /events. If 0, DefaultEventLogSize is
+		// used.
+		EventLogSize int `json:"eventLogSize"`
 	}
 )
 
@@ -46,6 +135,34 @@ func ReadConfig(filePath string) (*Config, error) {
 		c.Server.WhitelistedOrigin = defaultWhitelistedOrigin
 	}
 
+	if c.Server.MaxPayloadSize == 0 {
+		c.Server.MaxPayloadSize = DefaultMaxPayloadSize
+	}
+	if c.Server.MaxAssetSize == 0 {
+		c.Server.MaxAssetSize = DefaultMaxAssetSize
+	}
+
+	if c.Storage.Type == "" {
+		c.Storage.Type = StorageTypeMemory
+	}
+	switch c.Storage.Type {
+	case StorageTypeMemory:
+	case StorageTypeJournal:
+		if c.Storage.Path == "" {
+			return nil, fmt.Errorf("storage type %q requires a path", c.Storage.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", c.Storage.Type)
+	}
+
+	if c.Server.SIWEDomain == "" && c.Server.PublicURL != "" {
+		u, err := url.Parse(c.Server.PublicURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid publicUrl %q", c.Server.PublicURL)
+		}
+		c.Server.SIWEDomain = u.Host
+	}
+
 	return c, nil
 }
 
@@ -53,3 +170,30 @@ func ReadConfig(filePath string) (*Config, error) {
 func (c *ServerConfig) Addr() string {
 	return fmt.Sprintf("%s:%d", c.Host, c.Port)
 }
+
+// ShutdownTimeoutDuration returns the configured shutdown timeout or
+// DefaultShutdownTimeout.
+func (c *ServerConfig) ShutdownTimeoutDuration() time.Duration {
+	return seconds(c.ShutdownTimeout, DefaultShutdownTimeout)
+}
+
+// httpServer returns an http.Server serving handler with the configured
+// timeouts and limits.
+func (c *ServerConfig) httpServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       seconds(c.ReadTimeout, 0),
+		ReadHeaderTimeout: seconds(c.ReadHeaderTimeout, DefaultReadHeaderTimeout),
+		WriteTimeout:      seconds(c.WriteTimeout, 0),
+		IdleTimeout:       seconds(c.IdleTimeout, DefaultIdleTimeout),
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+// seconds returns s seconds as time.Duration, or def if s is 0.
+func seconds(s uint, def time.Duration) time.Duration {
+	if s == 0 {
+		return def
+	}
+	return time.Duration(s) * time.Second
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/perun-network/nerd-op/nftserv"
+)
+
+func TestReadConfig(t *testing.T) {
+	read := func(t *testing.T, config string) (*nftserv.Config, error) {
+		path := filepath.Join(t.TempDir(), "server.json")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+		return nftserv.ReadConfig(path)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		cfg, err := read(t, `{"server": {"publicUrl": "https://nft.example.com"}}`)
+		require.NoError(t, err)
+		require.EqualValues(t, nftserv.DefaultMaxPayloadSize, cfg.Server.MaxPayloadSize)
+		require.EqualValues(t, nftserv.DefaultMaxAssetSize, cfg.Server.MaxAssetSize)
+	})
+
+	t.Run("siwe domain", func(t *testing.T) {
+		cfg, err := read(t, `{"server": {"siweDomain": "nerd.example.com", "publicUrl": "https://nft.example.com"}}`)
+		require.NoError(t, err)
+		require.Equal(t, "nerd.example.com", cfg.Server.SIWEDomain)
+	})
+
+	t.Run("public url", func(t *testing.T) {
+		cfg, err := read(t, `{"server": {"publicUrl": "https://nft.example.com:8440/nerd"}}`)
+		require.NoError(t, err)
+		require.Equal(t, "nft.example.com:8440", cfg.Server.SIWEDomain)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg, err := read(t, `{"server": {}}`)
+		require.NoError(t, err)
+		require.Empty(t, cfg.Server.SIWEDomain)
+	})
+
+	t.Run("invalid public url", func(t *testing.T) {
+		_, err := read(t, `{"server": {"publicUrl": "nft.example.com"}}`)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+const (
+	// EIP712DomainName and EIP712DomainVersion are the name and version of the
+	// EIP-712 signing domain of the NFT server.
+	EIP712DomainName    = "NERD"
+	EIP712DomainVersion = "1"
+
+	eip712DomainType = "EIP712Domain(string name,string version,uint256 chainId)"
+	nftUpdateType    = "NFTUpdate(address token,uint256 id,uint256 assetId,bool secret,string title,string desc,string attributes,string nonce,uint256 deadline)"
+)
+
+var (
+	eip712DomainTypeHash = crypto.Keccak256([]byte(eip712DomainType))
+	nftUpdateTypeHash    = crypto.Keccak256([]byte(nftUpdateType))
+)
+
+type (
+	// NFTUpdate is the EIP-712 typed data that is signed to authorize an NFT
+	// metadata update. Attributes is the compact JSON encoding of the NFT's
+	// attributes, "[]" if it has none. Deadline is a unix timestamp in seconds
+	// after which the signature is not accepted anymore.
+	NFTUpdate struct {
+		Token      common.Address
+		ID         *big.Int
+		AssetID    uint
+		Secret     bool
+		Title      string
+		Desc       string
+		Attributes string
+		Nonce      string
+		Deadline   uint64
+	}
+
+	// eip712Field and eip712Types describe EIP-712 types in the JSON format
+	// expected by eth_signTypedData_v4.
+	eip712Field struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	eip712Types map[string][]eip712Field
+
+	eip712Response struct {
+		Types       eip712Types       `json:"types"`
+		PrimaryType string            `json:"primaryType"`
+		Domain      map[string]string `json:"domain"`
+	}
+)
+
+// NewNFTUpdate returns the NFTUpdate of tkn's metadata with the given nonce
+// and deadline.
+func NewNFTUpdate(tkn nft.NFT, nonce string, deadline uint64) (NFTUpdate, error) {
+	attrs := []byte("[]")
+	if len(tkn.Attributes) > 0 {
+		var err error
+		if attrs, err = compactJSON(tkn.Attributes); err != nil {
+			return NFTUpdate{}, fmt.Errorf("encoding attributes: %w", err)
+		}
+	}
+	return NFTUpdate{
+		Token:      tkn.Token,
+		ID:         tkn.ID,
+		AssetID:    tkn.AssetID,
+		Secret:     tkn.Secret,
+		Title:      tkn.Title,
+		Desc:       tkn.Desc,
+		Attributes: string(attrs),
+		Nonce:      nonce,
+		Deadline:   deadline,
+	}, nil
+}
+
+// TypedDataHash returns the EIP-712 digest of upd in the NFT server's domain
+// on the chain with id chainID. This is the hash signed by
+// eth_signTypedData_v4.
+func (upd NFTUpdate) TypedDataHash(chainID *big.Int) []byte {
+	return crypto.Keccak256(
+		[]byte{0x19, 0x01},
+		domainSeparator(chainID),
+		upd.structHash(),
+	)
+}
+
+func (upd NFTUpdate) structHash() []byte {
+	return crypto.Keccak256(
+		nftUpdateTypeHash,
+		common.LeftPadBytes(upd.Token.Bytes(), 32),
+		math.U256Bytes(new(big.Int).Set(upd.ID)),
+		math.U256Bytes(new(big.Int).SetUint64(uint64(upd.AssetID))),
+		encodeBool(upd.Secret),
+		crypto.Keccak256([]byte(upd.Title)),
+		crypto.Keccak256([]byte(upd.Desc)),
+		crypto.Keccak256([]byte(upd.Attributes)),
+		crypto.Keccak256([]byte(upd.Nonce)),
+		math.U256Bytes(new(big.Int).SetUint64(upd.Deadline)),
+	)
+}
+
+func domainSeparator(chainID *big.Int) []byte {
+	return crypto.Keccak256(
+		eip712DomainTypeHash,
+		crypto.Keccak256([]byte(EIP712DomainName)),
+		crypto.Keccak256([]byte(EIP712DomainVersion)),
+		math.U256Bytes(new(big.Int).Set(chainID)),
+	)
+}
+
+func encodeBool(b bool) []byte {
+	enc := make([]byte, 32)
+	if b {
+		enc[31] = 1
+	}
+	return enc
+}
+
+// handleGETeip712 returns the EIP-712 types, primary type and domain for NFT
+// updates, ready to be filled with a message and passed to
+// eth_signTypedData_v4.
+func (s *Server) handleGETeip712(w http.ResponseWriter, r *http.Request) {
+	resp := eip712Response{
+		Types: eip712Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+			},
+			"NFTUpdate": {
+				{Name: "token", Type: "address"},
+				{Name: "id", Type: "uint256"},
+				{Name: "assetId", Type: "uint256"},
+				{Name: "secret", Type: "bool"},
+				{Name: "title", Type: "string"},
+				{Name: "desc", Type: "string"},
+				{Name: "attributes", Type: "string"},
+				{Name: "nonce", Type: "string"},
+				{Name: "deadline", Type: "uint256"},
+			},
+		},
+		PrimaryType: "NFTUpdate",
+		Domain: map[string]string{
+			"name":    EIP712DomainName,
+			"version": EIP712DomainVersion,
+			"chainId": s.chainID().String(),
+		},
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error JSON-marshalling EIP-712 domain: %v", err)
+	}
+}
+
+func (s *Server) chainID() *big.Int {
+	return new(big.Int).SetUint64(s.cfg.ChainID)
+}
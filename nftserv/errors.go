@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/asset"
+	"github.com/perun-network/nerd-op/nft"
+)
+
+// Stable, machine-readable codes of errors that are not caused by the NFT or
+// asset storage. These use the codes of their sentinel errors, like
+// nft.CodeNotFound or asset.CodeNotFound.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInternal             = "internal"
+	CodeNotImplemented       = "not_implemented"
+	CodeUnavailable          = "unavailable"
+)
+
+// ErrorResponse is the JSON body of all error responses. Fields lists the
+// invalid fields of requests failing with nft.CodeInvalid.
+type ErrorResponse struct {
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Fields  []nft.FieldError `json:"fields,omitempty"`
+}
+
+// httpError responds with HTTP status code and an ErrorResponse with message
+// err and the code of the status, see statusErrorCode.
+func httpError(w http.ResponseWriter, err string, code int) {
+	writeError(w, code, ErrorResponse{Code: statusErrorCode(code), Message: err})
+}
+
+// storageError responds to err, returned by the NFT or asset storage. Known
+// errors, like nft.ErrNotFound, are responded to with their status and code,
+// see nft.ErrorCode. Others are internal errors, with message msg+err.
+func storageError(w http.ResponseWriter, msg string, err error) {
+	var verr nft.ValidationError
+	switch code := nft.ErrorCode(err); {
+	case errors.As(err, &verr):
+		writeError(w, http.StatusBadRequest, ErrorResponse{Code: code, Message: "Invalid NFT", Fields: verr})
+	case code == nft.CodeInvalid:
+		writeError(w, http.StatusBadRequest, ErrorResponse{Code: code, Message: err.Error()})
+	case code == nft.CodeConflict:
+		writeError(w, http.StatusPreconditionFailed, ErrorResponse{Code: code, Message: err.Error()})
+	case code != "":
+		writeError(w, http.StatusNotFound, ErrorResponse{Code: code, Message: err.Error()})
+	case errors.Is(err, asset.ErrNotFound):
+		writeError(w, http.StatusNotFound, ErrorResponse{Code: asset.CodeNotFound, Message: err.Error()})
+	default:
+		httpError(w, msg+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, resp ErrorResponse) {
+	log.Debugf("Responding error [%d] %s: %s", status, resp.Code, resp.Message)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error JSON-marshalling error response: %v", err)
+	}
+}
+
+// statusErrorCode returns the default error code of HTTP status code. Other
+// client and server errors are CodeBadRequest and CodeInternal.
+func statusErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status < http.StatusInternalServerError {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
+
+// errorWriter replaces the plain-text error responses of handlers like
+// http.ServeContent with ErrorResponses.
+type errorWriter struct {
+	http.ResponseWriter
+	failed bool
+}
+
+func (w *errorWriter) WriteHeader(status int) {
+	if status >= http.StatusBadRequest {
+		w.failed = true
+		httpError(w.ResponseWriter, http.StatusText(status), status)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if w.failed {
+		return len(p), nil // discard plain-text error message
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// handleNotFound responds to requests that match no route.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	httpError(w, "No such endpoint: "+r.URL.Path, http.StatusNotFound)
+}
+
+// handleMethodNotAllowed responds to requests with a method that the matched
+// route doesn't support.
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	httpError(w, "Method not allowed: "+r.Method, http.StatusMethodNotAllowed)
+}
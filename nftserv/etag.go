@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+// etagLen is the number of bytes of the SHA-256 digest used as ETag.
+const etagLen = 16
+
+// etag returns the strong ETag of NFT tkn. It is derived from the NFT's JSON
+// representation, so it changes whenever any field of the NFT changes.
+func etag(tkn nft.NFT) (string, error) {
+	data, err := json.Marshal(tkn)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:etagLen]) + `"`, nil
+}
+
+// matchesETag reports whether the If-Match or If-None-Match header value
+// header matches tag. Weak entity tags never match, following the strong
+// comparison of RFC 7232.
+func matchesETag(header, tag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
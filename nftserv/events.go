@@ -0,0 +1,318 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+// Types of NFT change events.
+const (
+	// EventCreate is sent when an NFT is first stored, usually when it shows up
+	// in a balance update of the operator.
+	EventCreate = "create"
+	// EventUpdate is sent when an NFT's metadata, state or royalty changes.
+	EventUpdate = "update"
+	// EventOwner is sent when an NFT's owner changes.
+	EventOwner = "owner"
+	// EventReset is sent to resuming clients if the events after their
+	// Last-Event-ID are not in the event log anymore. Clients should reload
+	// the NFTs they are interested in.
+	EventReset = "reset"
+
+	// DefaultEventLogSize is the number of retained events if none is
+	// configured.
+	DefaultEventLogSize = 1024
+
+	// eventKeepAlive is the interval of keep-alive comments on idle event
+	// streams.
+	eventKeepAlive = 30 * time.Second
+)
+
+type (
+	// Event is an NFT change event, sent as Server-Sent Event on GET /events.
+	// It doesn't contain the NFT's metadata, which may be secret. Clients can
+	// get it from GET /nft/{token}/{id}.
+	Event struct {
+		// EventID is the consecutive event id, starting at 1 on server start.
+		EventID uint64         `json:"eventId"`
+		Type    string         `json:"type"`
+		Token   common.Address `json:"token"`
+		ID      string         `json:"id"`
+		Owner   common.Address `json:"owner"`
+		// PreviousOwner is only set on EventOwner.
+		PreviousOwner *common.Address `json:"previousOwner,omitempty"`
+		Time          time.Time       `json:"time"`
+	}
+
+	// eventLog retains the last events and notifies subscribers of new ones.
+	eventLog struct {
+		mu     sync.Mutex
+		events []Event // consecutive events, oldest first
+		size   int
+		lastID uint64
+		subs   map[chan struct{}]struct{}
+		done   chan struct{} // closed on close
+		closed bool
+	}
+
+	// eventFilter selects the events sent on a stream.
+	eventFilter struct {
+		owner, token *common.Address
+	}
+
+	// eventStorage is an nft.Storage that publishes all NFT changes to an
+	// eventLog. Changes are serialized so that every change is attributed
+	// to the right event.
+	eventStorage struct {
+		nft.Storage
+		mu     sync.Mutex
+		events *eventLog
+	}
+)
+
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = DefaultEventLogSize
+	}
+	return &eventLog{
+		size: size,
+		subs: make(map[chan struct{}]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// publish appends event e with the next event id to the log and notifies all
+// subscribers.
+func (l *eventLog) publish(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	e.EventID = l.lastID
+	if len(l.events) == l.size {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, e)
+	for sub := range l.subs {
+		select {
+		case sub <- struct{}{}:
+		default: // already notified
+		}
+	}
+}
+
+// since returns the retained events after event id. If these are incomplete,
+// because id is too old or unknown, reset is true and all retained events
+// are returned.
+func (l *eventLog) since(id uint64) (events []Event, reset bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if id > l.lastID {
+		return append([]Event(nil), l.events...), true
+	} else if len(l.events) == 0 || id == l.lastID {
+		return nil, false
+	} else if first := l.events[0].EventID; id+1 < first {
+		return append([]Event(nil), l.events...), true
+	} else {
+		return append([]Event(nil), l.events[id+1-first:]...), false
+	}
+}
+
+// latest returns the id of the last event.
+func (l *eventLog) latest() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID
+}
+
+// subscribe returns a channel that receives a value whenever new events are
+// published and a function to cancel the subscription.
+func (l *eventLog) subscribe() (<-chan struct{}, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sub := make(chan struct{}, 1)
+	l.subs[sub] = struct{}{}
+	return sub, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs, sub)
+	}
+}
+
+// close ends all event streams.
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+}
+
+// match reports whether event e passes filter f. The owner filter matches
+// both the new and the previous owner.
+func (f eventFilter) match(e Event) bool {
+	return (f.token == nil || *f.token == e.Token) &&
+		(f.owner == nil || *f.owner == e.Owner ||
+			(e.PreviousOwner != nil && *f.owner == *e.PreviousOwner))
+}
+
+func newEventStorage(s nft.Storage, events *eventLog) *eventStorage {
+	return &eventStorage{Storage: s, events: events}
+}
+
+func (s *eventStorage) Upsert(tkn nft.NFT) error {
+	return s.change(tkn.Token, tkn.ID, func() error { return s.Storage.Upsert(tkn) })
+}
+
+func (s *eventStorage) CompareAndUpsert(old, tkn nft.NFT) error {
+	return s.change(tkn.Token, tkn.ID, func() error { return s.Storage.CompareAndUpsert(old, tkn) })
+}
+
+func (s *eventStorage) UpsertFields(tkn nft.NFT, mask nft.FieldMask) error {
+	return s.change(tkn.Token, tkn.ID, func() error { return s.Storage.UpsertFields(tkn, mask) })
+}
+
+func (s *eventStorage) CompareAndUpsertFields(old, tkn nft.NFT, mask nft.FieldMask) error {
+	return s.change(tkn.Token, tkn.ID, func() error { return s.Storage.CompareAndUpsertFields(old, tkn, mask) })
+}
+
+func (s *eventStorage) Rollback(token common.Address, id *big.Int, rev uint64) (updated nft.NFT, err error) {
+	err = s.change(token, id, func() (err error) {
+		updated, err = s.Storage.Rollback(token, id, rev)
+		return
+	})
+	return
+}
+
+func (s *eventStorage) CompareAndRollback(old nft.NFT, rev uint64) (updated nft.NFT, err error) {
+	err = s.change(old.Token, old.ID, func() (err error) {
+		updated, err = s.Storage.CompareAndRollback(old, rev)
+		return
+	})
+	return
+}
+
+// change applies the change fn to NFT (token, id) and publishes the resulting
+// event, if the NFT changed.
+func (s *eventStorage) change(token common.Address, id *big.Int, fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, oldErr := s.Storage.Get(token, id)
+	if err := fn(); err != nil {
+		return err
+	}
+	tkn, err := s.Storage.Get(token, id)
+	if err != nil {
+		log.Errorf("Server: Error reading changed NFT %v/%v for event: %v", token, id, err)
+		return nil
+	}
+
+	e := Event{Token: token, ID: id.Text(10), Owner: tkn.Owner, Time: time.Now()}
+	switch {
+	case oldErr != nil:
+		e.Type = EventCreate
+	case old.Owner != tkn.Owner:
+		e.Type, e.PreviousOwner = EventOwner, &old.Owner
+	case !old.Equal(tkn):
+		e.Type = EventUpdate
+	default:
+		return nil
+	}
+	s.events.publish(e)
+	return nil
+}
+
+// handleGETevents streams NFT change events as Server-Sent Events. Query
+// parameters owner and token filter the events. If header Last-Event-ID or
+// query parameter lastEventId is set, the stream resumes after this event.
+// Otherwise, only new events are sent.
+func (s *Server) handleGETevents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	var (
+		filter eventFilter
+		err    error
+		vals   = r.URL.Query()
+	)
+	if filter.owner, err = parseAddressParam(vals, "owner"); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if filter.token, err = parseAddressParam(vals, "token"); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lastIDStr := r.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = vals.Get("lastEventId")
+	}
+
+	// Subscribe before reading the latest event id so that no event is missed.
+	notify, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+	lastID := s.events.latest()
+	if lastIDStr != "" {
+		if lastID, err = strconv.ParseUint(lastIDStr, 10, 64); err != nil {
+			httpError(w, fmt.Sprintf("Invalid last event id %q", lastIDStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		events, reset := s.events.since(lastID)
+		if reset {
+			resetID := s.events.latest()
+			if len(events) > 0 {
+				resetID = events[0].EventID - 1
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", resetID, EventReset)
+			lastID = resetID
+		}
+		for _, e := range events {
+			lastID = e.EventID
+			if !filter.match(e) {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Errorf("Error JSON-marshalling event %v: %v", e, err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.EventID, e.Type, data)
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.events.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"net"
+	"sync"
+)
+
+type (
+	// limitListener limits the number of simultaneously open connections
+	// accepted from a net.Listener. Accept blocks while the limit is reached.
+	limitListener struct {
+		net.Listener
+		sem       chan struct{}
+		done      chan struct{} // closed on Close to unblock Accept
+		closeOnce sync.Once
+	}
+
+	// limitConn releases its slot of the limitListener when closed.
+	limitConn struct {
+		net.Conn
+		releaseOnce sync.Once
+		release     func()
+	}
+)
+
+// newLimitListener returns a listener accepting at most n simultaneous
+// connections from l.
+func newLimitListener(l net.Listener, n int) *limitListener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
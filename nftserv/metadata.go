@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+type (
+	// Metadata is the ERC-721 Metadata JSON Schema representation of an NFT,
+	// as returned by a tokenURI and extended by OpenSea with attributes and
+	// external_url.
+	Metadata struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Image       string          `json:"image,omitempty"`
+		ExternalURL string          `json:"external_url,omitempty"`
+		Attributes  []nft.Attribute `json:"attributes"`
+		// Royalty is the NFT's royalty, falling back to the collection's.
+		Royalty *nft.Royalty `json:"royalty,omitempty"`
+	}
+)
+
+// metadata returns the ERC-721 metadata of tkn. Absolute URLs are built from
+// the configured public and external base URLs. baseURL is used as public base
+// URL if none is configured.
+func (s *Server) metadata(tkn nft.NFT, baseURL string) Metadata {
+	if s.cfg.PublicURL != "" {
+		baseURL = s.cfg.PublicURL
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	md := Metadata{
+		Name:        tkn.Title,
+		Description: tkn.Desc,
+		Attributes:  tkn.Attributes,
+	}
+	if md.Attributes == nil {
+		md.Attributes = []nft.Attribute{} // encode as empty JSON array instead of null
+	}
+	if md.Name == "" {
+		md.Name = "#" + tkn.ID.String()
+	}
+	if tkn.AssetID != 0 {
+		md.Image = fmt.Sprintf("%s/nft/%s/%s/asset", baseURL, tkn.Token.Hex(), tkn.ID)
+	}
+	if s.cfg.ExternalURL != "" {
+		md.ExternalURL = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(s.cfg.ExternalURL, "/"), tkn.Token.Hex(), tkn.ID)
+	}
+	return md
+}
+
+// handleGETmetadata returns the NFT's ERC-721 metadata. Secret NFTs are
+// redacted unless the request is signed by the owner.
+func (s *Server) handleGETmetadata(w http.ResponseWriter, r *http.Request) {
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		viewer, ok := s.viewer(w, r)
+		if !ok {
+			return
+		}
+		if tkn.Secret {
+			setPrivate(w)
+		}
+		md := s.metadata(tkn.RedactedFor(viewer), requestBaseURL(r))
+		royalty, err := s.royaltyOf(tkn)
+		if err != nil {
+			httpError(w, "Error reading collection: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		md.Royalty = royalty
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(md); err != nil {
+			log.Errorf("Error JSON-marshalling metadata of %v: %v", tkn, err)
+		}
+	})
+}
+
+// requestBaseURL returns the base URL under which request r reached the
+// server.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+// MergePatchContentType is the content type of JSON Merge Patch (RFC 7396)
+// requests.
+const MergePatchContentType = "application/merge-patch+json"
+
+// handlePATCHnft applies the JSON Merge Patch in the request body to the NFT's
+// metadata and returns the updated NFT. Other than on PUT, fields can be
+// cleared by setting them to null and secret can be set to false.
+func (s *Server) handlePATCHnft(w http.ResponseWriter, r *http.Request) {
+	if !s.limitPayload(w, r) {
+		return
+	}
+	if ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || ct != MergePatchContentType {
+		httpError(w, "Content-Type must be "+MergePatchContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		payloadError(w, "Error reading payload: ", err)
+		return
+	}
+
+	token, id := mustReadTokenID(r)
+	src, mask, err := nft.ParseMergePatch(token, id, patch)
+	if err != nil {
+		httpError(w, "Invalid merge patch: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if !s.validateNFT(w, src) {
+		return
+	}
+
+	tkn, err := s.nfts.Get(token, id)
+	if err != nil {
+		storageError(w, "Error reading existing token: ", err)
+		return
+	} else if !s.authorizeOwner(w, r, tkn.Owner, ActionPatch, json.RawMessage(patch)) {
+		return
+	}
+
+	updated, ok := s.updateNFT(w, r, tkn, src, mask, nil)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		log.Errorf("Error JSON-marshalling token %v: %v", updated, err)
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+const (
+	// MaxPageLimit is the maximal value of query parameter limit on GET /nfts.
+	MaxPageLimit = 1000
+
+	// NextCursorHeader is the response header holding the cursor of the next
+	// page on GET /nfts. It is not set on the last page.
+	NextCursorHeader = "X-Next-Cursor"
+)
+
+// parseNFTsQuery parses the query parameters of a GET /nfts request.
+func parseNFTsQuery(vals url.Values) (q nft.Query, err error) {
+	if q.Owner, err = parseAddressParam(vals, "owner"); err != nil {
+		return
+	}
+	if q.Token, err = parseAddressParam(vals, "token"); err != nil {
+		return
+	}
+	if q.Secret, err = parseBoolParam(vals, "secret"); err != nil {
+		return
+	}
+	if q.HasAsset, err = parseBoolParam(vals, "hasAsset"); err != nil {
+		return
+	}
+
+	for _, t := range vals["trait"] {
+		kv := strings.SplitN(t, ":", 2)
+		if len(kv) != 2 {
+			return q, fmt.Errorf("invalid trait %q, must be {type}:{value}", t)
+		}
+		q.Traits = append(q.Traits, nft.Trait{Type: kv[0], Value: kv[1]})
+	}
+
+	switch order := vals.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("invalid order %q, must be asc or desc", order)
+	}
+
+	if l := vals.Get("limit"); l != "" {
+		if q.Limit, err = strconv.Atoi(l); err != nil || q.Limit <= 0 || q.Limit > MaxPageLimit {
+			return q, fmt.Errorf("invalid limit %q, must be in range [1, %d]", l, MaxPageLimit)
+		}
+	}
+
+	if c := vals.Get("cursor"); c != "" {
+		if q.AfterToken, q.AfterID, err = decodeCursor(c); err != nil {
+			return q, fmt.Errorf("invalid cursor: %w", err)
+		}
+	}
+	return q, nil
+}
+
+func parseAddressParam(vals url.Values, key string) (*common.Address, error) {
+	s := vals.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	if !common.IsHexAddress(s) {
+		return nil, fmt.Errorf("invalid %s address %q", key, s)
+	}
+	addr := common.HexToAddress(s)
+	return &addr, nil
+}
+
+func parseBoolParam(vals url.Values, key string) (*bool, error) {
+	s := vals.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q", key, s)
+	}
+	return &b, nil
+}
+
+// encodeCursor encodes the position of NFT (token, id) into an opaque cursor.
+func encodeCursor(token common.Address, id *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(append(token.Bytes(), id.Bytes()...))
+}
+
+func decodeCursor(c string) (common.Address, *big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return common.Address{}, nil, err
+	} else if len(data) < common.AddressLength {
+		return common.Address{}, nil, errors.New("too short")
+	}
+	return common.BytesToAddress(data[:common.AddressLength]),
+		new(big.Int).SetBytes(data[common.AddressLength:]), nil
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/nft"
+)
+
+type (
+	// royaltyInfoResponse is the result of the EIP-2981 royaltyInfo view
+	// function.
+	royaltyInfoResponse struct {
+		Receiver      common.Address `json:"receiver"`
+		RoyaltyAmount string         `json:"royaltyAmount"`
+	}
+
+	// royaltyPayload is the payload of the AuthMessage of a royalty override.
+	// Royalty is nil if the override is removed.
+	royaltyPayload struct {
+		Token   common.Address `json:"token"`
+		ID      string         `json:"id"`
+		Royalty *nft.Royalty   `json:"royalty"`
+	}
+)
+
+// royaltyOf returns the royalty of tkn, falling back to the royalty of its
+// collection, or nil if neither is set.
+func (s *Server) royaltyOf(tkn nft.NFT) (*nft.Royalty, error) {
+	if tkn.Royalty != nil {
+		return tkn.Royalty, nil
+	}
+	coll, err := s.nfts.GetCollection(tkn.Token)
+	if errors.Is(err, nft.ErrCollectionNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return nft.RoyaltyOf(tkn, &coll), nil
+}
+
+// handleGETnftRoyalty returns the royalty receiver and amount for a sale of
+// the NFT at the price given in query parameter salePrice, like the EIP-2981
+// function royaltyInfo. Without royalty, the zero address and amount 0 are
+// returned.
+func (s *Server) handleGETnftRoyalty(w http.ResponseWriter, r *http.Request) {
+	salePrice, ok := new(big.Int).SetString(r.URL.Query().Get("salePrice"), 10)
+	if !ok || salePrice.Sign() < 0 || salePrice.BitLen() > 256 {
+		httpError(w, "Query parameter salePrice must be a uint256 in base 10", http.StatusBadRequest)
+		return
+	}
+
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		royalty, err := s.royaltyOf(tkn)
+		if err != nil {
+			httpError(w, "Error reading collection: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var resp royaltyInfoResponse
+		if royalty != nil {
+			// Like Solidity's checked arithmetic, fail if the intermediate
+			// product salePrice * basisPoints overflows uint256.
+			if new(big.Int).Mul(salePrice, big.NewInt(int64(royalty.BasisPoints))).Cmp(math.MaxBig256) > 0 {
+				httpError(w, "Royalty computation overflows uint256", http.StatusBadRequest)
+				return
+			}
+			resp = royaltyInfoResponse{Receiver: royalty.Receiver, RoyaltyAmount: royalty.Amount(salePrice).String()}
+		} else {
+			resp.RoyaltyAmount = "0"
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error JSON-marshalling royalty info of %v: %v", tkn, err)
+		}
+	})
+}
+
+// handlePUTnftRoyalty sets the royalty of the NFT, overriding the royalty of
+// its collection. The request must be signed by the collection admin.
+func (s *Server) handlePUTnftRoyalty(w http.ResponseWriter, r *http.Request) {
+	if !s.limitPayload(w, r) {
+		return
+	}
+	var royalty nft.Royalty
+	if err := json.NewDecoder(r.Body).Decode(&royalty); err != nil {
+		payloadError(w, "Error decoding royalty from payload: ", err)
+		return
+	} else if err := royalty.Validate(); err != nil {
+		httpError(w, "Invalid royalty: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		payload := royaltyPayload{Token: tkn.Token, ID: tkn.ID.String(), Royalty: &royalty}
+		if !s.authorizeAdmin(w, r, ActionRoyalty, payload) {
+			return
+		}
+		if err := s.nfts.Upsert(nft.NFT{Token: tkn.Token, ID: tkn.ID, Royalty: &royalty}); err != nil {
+			httpError(w, "Error updating token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// handleDELETEnftRoyalty removes the royalty override of the NFT, so that the
+// royalty of its collection applies again. The request must be signed by the
+// collection admin.
+func (s *Server) handleDELETEnftRoyalty(w http.ResponseWriter, r *http.Request) {
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		payload := royaltyPayload{Token: tkn.Token, ID: tkn.ID.String()}
+		if !s.authorizeAdmin(w, r, ActionRoyalty, payload) {
+			return
+		}
+		if err := s.nfts.UpsertFields(nft.NFT{Token: tkn.Token, ID: tkn.ID}, nft.FieldMask{nft.FieldRoyalty}); err != nil {
+			httpError(w, "Error updating token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -3,11 +3,16 @@
 package nftserv
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/gorilla/mux"
@@ -20,28 +25,69 @@ import (
 )
 
 type Server struct {
-	r      *mux.Router
-	nfts   nft.Storage
-	assets asset.Storage
-	cfg    ServerConfig
+	r        *mux.Router
+	srv      *http.Server
+	nfts     nft.Storage
+	assets   asset.Storage
+	cfg      ServerConfig
+	nonces   *nonceStore
+	sessions *sessionSigner
+	events   *eventLog
+
+	// mu guards closed. Balance updates hold the read lock, so that Shutdown
+	// waits for in-flight updates.
+	mu     sync.RWMutex
+	closed bool
 }
 
 func New(nftStorage nft.Storage, assetStorage asset.Storage, cfg ServerConfig) *Server {
 	s := &Server{
 		r:      mux.NewRouter(),
-		nfts:   nftStorage,
 		assets: assetStorage,
 		cfg:    cfg,
+		events: newEventLog(cfg.EventLogSize),
+	}
+	// All NFT changes go through the event storage to publish them as events.
+	s.nfts = newEventStorage(nftStorage, s.events)
+	sessions, err := newSessionSigner(cfg.SessionSecret, time.Duration(cfg.SessionTTL)*time.Second)
+	if err != nil {
+		log.Panicf("NFT Server: creating session signer: %v", err)
+	}
+	s.sessions = sessions
+	if s.nonces, err = newNonceStore(); err != nil {
+		log.Panicf("NFT Server: creating nonce store: %v", err)
 	}
 	s.r.HandleFunc("/status", s.handleGETstatus).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/auth/nonce", s.handleGETnonce).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/auth/login", s.handlePOSTlogin).Methods(http.MethodPost, http.MethodOptions)
+	s.r.HandleFunc("/auth/eip712", s.handleGETeip712).Methods(http.MethodGet, http.MethodOptions)
 	const tokenIdSelector = "/{token:0x[0-9a-fA-F]{40}}/{id:[0-9]+}"
 	s.r.HandleFunc("/nft"+tokenIdSelector, s.handlePUTnft).Methods(http.MethodPut, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector, s.handlePATCHnft).Methods(http.MethodPatch, http.MethodOptions)
 	s.r.HandleFunc("/nft"+tokenIdSelector, s.handleGETnft).Methods(http.MethodGet, http.MethodOptions)
-	s.r.HandleFunc("/nft"+tokenIdSelector+"/asset", s.handleGETnftAsset).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/asset", s.handleGETnftAsset).Methods(http.MethodGet, http.MethodHead, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/royalty", s.handleGETnftRoyalty).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/royalty", s.handlePUTnftRoyalty).Methods(http.MethodPut, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/royalty", s.handleDELETEnftRoyalty).Methods(http.MethodDelete, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/history", s.handleGETnftHistory).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/revisions", s.handleGETnftRevisions).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/revisions/{rev:[0-9]+}", s.handleGETnftRevision).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/nft"+tokenIdSelector+"/revisions/{rev:[0-9]+}/rollback", s.handlePOSTnftRollback).Methods(http.MethodPost, http.MethodOptions)
+	const tokenSelector = "/{token:0x[0-9a-fA-F]{40}}"
+	s.r.HandleFunc("/collection"+tokenSelector, s.handleGETcollection).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/collection"+tokenSelector, s.handlePUTcollection).Methods(http.MethodPut, http.MethodOptions)
+	s.r.HandleFunc("/metadata"+tokenIdSelector, s.handleGETmetadata).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/assets", s.handlePOSTasset).Methods(http.MethodPost, http.MethodOptions)
 	s.r.HandleFunc("/nfts", s.handleGETnfts).Methods(http.MethodGet, http.MethodOptions)
+	s.r.HandleFunc("/events", s.handleGETevents).Methods(http.MethodGet, http.MethodOptions)
+
+	s.r.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	s.r.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
 
 	s.r.Use(mux.CORSMethodMiddleware(s.r))
 	s.r.Use(AllowCORSForOrigin(cfg.WhitelistedOrigin))
+	s.r.Use(s.SessionMiddleware)
+	s.srv = cfg.httpServer(s.r)
 
 	return s
 }
@@ -52,6 +98,9 @@ func AllowCORSForOrigin(origin string) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, If-Modified-Since, Range, Last-Event-ID, "+
+				NonceHeader+", "+SignatureHeader+", "+SignatureSchemeHeader+", "+DeadlineHeader)
+			w.Header().Set("Access-Control-Expose-Headers", "Accept-Ranges, Content-Range, ETag, "+NextCursorHeader)
 			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusOK)
 			} else {
@@ -63,15 +112,55 @@ func AllowCORSForOrigin(origin string) mux.MiddlewareFunc {
 
 // UpdateBalance is the balance handler that can be injected into the operator
 // with Operator.OnNewBalance.
+//
+// NFTs of `owner` that are not part of `acc` anymore are marked as
+// nft.StateWithdrawn.
+//
+// After Shutdown, balance updates are ignored.
 func (s *Server) UpdateBalance(owner common.Address, acc tee.Account) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		log.Warnf("Server.UpdateBalance: Ignoring balance of %v after shutdown", owner)
+		return
+	}
+
 	nfts := nft.Extract(owner, acc)
 	for _, nft := range nfts {
 		if err := s.nfts.Upsert(nft); err != nil {
 			log.Errorf("Server.UpdateBalance: Error upserting NFT %v: %v", nft, err)
 		}
 	}
+
+	stored, err := s.nfts.GetByOwner(owner)
+	if err != nil {
+		log.Errorf("Server.UpdateBalance: Error reading stored NFTs of owner: %v", err)
+		return
+	}
+	byKey := make(map[string]nft.NFT, len(stored))
+	for _, tkn := range stored {
+		byKey[nftKey(tkn)] = tkn
+	}
+	for _, gone := range nft.Gone(owner, acc, stored) {
+		// The NFT may have been moved to its new owner concurrently, so it is
+		// only marked if it didn't change since it was read.
+		err := s.nfts.CompareAndUpsert(byKey[nftKey(gone)], gone)
+		if errors.Is(err, nft.ErrConflict) {
+			log.Debugf("Server.UpdateBalance: NFT %v changed concurrently, not marking it as gone", &gone)
+		} else if err != nil {
+			log.Errorf("Server.UpdateBalance: Error marking NFT %v as gone: %v", &gone, err)
+		}
+	}
+}
+
+// nftKey returns a string uniquely identifying the NFT.
+func nftKey(tkn nft.NFT) string {
+	return tkn.Token.Hex() + "/" + tkn.ID.Text(10)
 }
 
+// Serve listens on the configured address and serves the NFT server, using TLS
+// if a certificate and key are configured. After Shutdown, it returns
+// http.ErrServerClosed.
 func (s *Server) Serve() error {
 	addr := s.cfg.Addr()
 	cert := s.cfg.CertFile
@@ -83,19 +172,75 @@ func (s *Server) Serve() error {
 }
 
 func (s *Server) ListenAndServe(addr string) error {
-	return http.ListenAndServe(addr, s.r)
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.srv.Serve(l)
 }
 
 func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
-	return http.ListenAndServeTLS(addr, certFile, keyFile, s.r)
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+	return s.srv.ServeTLS(l, certFile, keyFile)
+}
+
+// listen listens on TCP address addr, limiting the number of simultaneous
+// connections to the configured MaxConns.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	s.srv.Addr = addr
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.MaxConns > 0 {
+		return newLimitListener(l, s.cfg.MaxConns), nil
+	}
+	return l, nil
+}
+
+// Shutdown gracefully shuts down the server. It stops accepting connections
+// and balance updates, ends all event streams and waits for in-flight
+// requests and balance updates to finish, or until ctx is done. Afterwards,
+// the NFT storage can be closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.events.close()
+	return s.srv.Shutdown(ctx)
 }
 
 func (s *Server) handleGETstatus(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// handleGETnft returns the NFT. Secret NFTs are redacted unless the request is
+// signed by the owner.
 func (s *Server) handleGETnft(w http.ResponseWriter, r *http.Request) {
 	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		viewer, ok := s.viewer(w, r)
+		if !ok {
+			return
+		}
+		if tkn.Secret {
+			setPrivate(w)
+		}
+		tkn = tkn.RedactedFor(viewer)
+
+		tag, err := etag(tkn)
+		if err != nil {
+			httpError(w, "Error computing ETag: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", tag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" && matchesETag(inm, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		if err := json.NewEncoder(w).Encode(tkn); err != nil {
 			log.Errorf("Error JSON-marshalling %v: %v", tkn, err)
@@ -104,59 +249,128 @@ func (s *Server) handleGETnft(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleGETnfts(w http.ResponseWriter, r *http.Request) {
-	tkns, err := s.nfts.GetAll()
+	q, err := parseNFTsQuery(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewer, ok := s.viewer(w, r)
+	if !ok {
+		return
+	}
+	q.Redact, q.Viewer = true, viewer
+
+	tkns, more, err := nft.Select(s.nfts, q)
 	if err != nil {
 		httpError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if tkns == nil {
+		tkns = []nft.NFT{} // encode as empty JSON array instead of null
+	}
+	// Secret NFTs are redacted and filtered for the viewer.
+	setPrivate(w)
+	if more {
+		last := tkns[len(tkns)-1]
+		w.Header().Set(NextCursorHeader, encodeCursor(last.Token, last.ID))
+	}
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	if err := json.NewEncoder(w).Encode(tkns); err != nil {
 		log.Errorf("Error JSON-marshalling all tokens: %v", err)
 	}
 }
 
+// handleGETnftAsset streams the NFT's asset, supporting range and conditional
+// requests. Assets of secret NFTs are only returned if the request is signed
+// by the owner.
 func (s *Server) handleGETnftAsset(w http.ResponseWriter, r *http.Request) {
 	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
-		ast, err := s.assets.Get(big.NewInt(int64(tkn.AssetID)))
+		if tkn.Secret {
+			viewer, ok := s.viewer(w, r)
+			if !ok {
+				return
+			} else if viewer == eth.Zero {
+				httpError(w, "Secret asset requires owner authentication", http.StatusUnauthorized)
+				return
+			} else if viewer != tkn.Owner {
+				httpError(w, "Secret asset is only accessible by the owner", http.StatusForbidden)
+				return
+			}
+		}
+
+		if tkn.AssetID == 0 { // no asset set, don't look up the reserved id
+			storageError(w, "", asset.ErrNotFound)
+			return
+		}
+		ast, err := s.assets.Get(new(big.Int).SetUint64(uint64(tkn.AssetID)))
 		if err != nil {
-			httpError(w, "asset not found: "+err.Error(), http.StatusNotFound)
+			storageError(w, "Error opening asset: ", err)
+			return
 		}
+		defer ast.Close()
 
-		w.Header().Set("Content-Type", "application/octet-stream")
-		if _, err := w.Write(ast); err != nil {
-			log.Errorf("Error sending asset for token %v: %v", tkn, err)
+		if tkn.Secret {
+			setPrivate(w)
+		}
+		ctype := ast.ContentType()
+		w.Header().Set("Content-Type", ctype)
+		// Assets are user content, so browsers must neither sniff their type
+		// nor run scripts in them.
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		if !isMediaType(ctype) {
+			w.Header().Set("Content-Disposition", "attachment")
 		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-%x"`, tkn.AssetID, ast.Size(), ast.ModTime().UnixNano()))
+		// ServeContent handles Range, If-Modified-Since, If-None-Match and HEAD.
+		http.ServeContent(&errorWriter{ResponseWriter: w}, r, "", ast.ModTime(), ast)
 	})
 }
 
+func (s *Server) handleGETnftHistory(w http.ResponseWriter, r *http.Request) {
+	token, id := mustReadTokenID(r)
+	hist, err := s.nfts.History(token, id)
+	if err != nil {
+		storageError(w, "", err)
+		return
+	}
+	if hist == nil {
+		hist = []nft.OwnerChange{} // encode as empty JSON array instead of null
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(hist); err != nil {
+		log.Errorf("Error JSON-marshalling history of token %v/%v: %v", token, id, err)
+	}
+}
+
 func (s *Server) handleNFTRequest(w http.ResponseWriter, r *http.Request, handler func(nft.NFT)) {
 	var (
 		token, id = mustReadTokenID(r)
 		tkn, err  = s.nfts.Get(token, id)
 	)
 
-	if errors.Is(err, nft.ErrNotFound) {
-		httpError(w, err.Error(), http.StatusNotFound)
-		return
-	} else if err != nil {
-		httpError(w, err.Error(), http.StatusInternalServerError)
+	if err != nil {
+		storageError(w, "", err)
 		return
 	}
 	handler(tkn)
 }
 
 func (s *Server) handlePUTnft(w http.ResponseWriter, r *http.Request) {
-	if s.cfg.MaxPayloadSize > 0 && r.ContentLength >= int64(s.cfg.MaxPayloadSize) {
-		http.Error(w, "NFT title and description too large", http.StatusRequestEntityTooLarge)
+	if !s.limitPayload(w, r) {
+		return
 	}
 
 	var newtkn nft.NFT
 	if err := json.NewDecoder(r.Body).Decode(&newtkn); err != nil {
-		http.Error(w, "Error decoding token from payload: "+err.Error(), http.StatusBadRequest)
+		payloadError(w, "Error decoding token from payload: ", err)
 		return
 	}
 
 	token, id := mustReadTokenID(r)
+	// The lifecycle state is only managed by the operator and royalty
+	// overrides only by the collection admin.
+	newtkn.State, newtkn.Royalty = "", nil
 
 	log.Debug("RECEIVED PUT")
 	log.Debugf("token: %v", token)
@@ -167,17 +381,211 @@ func (s *Server) handlePUTnft(w http.ResponseWriter, r *http.Request) {
 		httpError(w, "Token or ID mismatch between payload and URL", http.StatusBadRequest)
 		return
 	}
+	if !s.validateNFT(w, newtkn) {
+		return
+	}
 
 	tkn, err := s.nfts.Get(token, id)
-	if err != nil && !errors.Is(err, nft.ErrNotFound) {
-		httpError(w, "Error reading existing token: "+err.Error(), http.StatusInternalServerError)
+	if err != nil {
+		storageError(w, "Error reading existing token: ", err)
 		return
-	} else if err == nil && tkn.Owner != eth.Zero && tkn.Owner != newtkn.Owner { // allow fast PUTter for now...
-		httpError(w, fmt.Sprintf("Existing token has different owner: %v", tkn), http.StatusConflict)
+	} else if !checkOwner(w, tkn, newtkn.Owner) ||
+		!s.authorizeOwner(w, r, tkn.Owner, ActionUpdate, newtkn) {
 		return
 	}
+	s.updateNFT(w, r, tkn, newtkn, nil, nil)
+}
+
+// updateNFT updates the stored NFT tkn with newtkn, explicitly setting the
+// fields in mask, and sets the ETag header of the updated NFT, which it
+// returns. The update is only applied if the stored NFT still equals tkn, so
+// that concurrent changes, like transfers, aren't overwritten. If request r
+// has an If-Match header, it must also match the ETag of tkn. A new asset must
+// be authorized, see authorizeAsset. Otherwise, it responds with an error and
+// returns false.
+//
+// If write is not nil, it applies the update instead of
+// Storage.CompareAndUpsertFields and must fail with nft.ErrConflict if the
+// stored NFT doesn't equal tkn.
+func (s *Server) updateNFT(w http.ResponseWriter, r *http.Request, tkn, newtkn nft.NFT, mask nft.FieldMask, write func() error) (nft.NFT, bool) {
+	// Owners are only managed by the operator, the owner in the payload was
+	// only checked by checkOwner.
+	newtkn.Owner = eth.Zero
+	target := tkn
+	target.UpdateFields(newtkn, mask)
+	if target.AssetID != 0 && target.AssetID != tkn.AssetID && !s.authorizeAsset(w, tkn.Owner, target.AssetID) {
+		return nft.NFT{}, false
+	}
+
+	// Optimistic concurrency control: only update if the client's view of the
+	// token is current.
+	im := r.Header.Get("If-Match")
+	if im != "" {
+		tag, err := etag(tkn)
+		if err != nil {
+			httpError(w, "Error computing ETag: "+err.Error(), http.StatusInternalServerError)
+			return nft.NFT{}, false
+		} else if !matchesETag(im, tag) {
+			storageError(w, "", fmt.Errorf("%w: ETag mismatch", nft.ErrConflict))
+			return nft.NFT{}, false
+		}
+	}
+	if write == nil {
+		write = func() error { return s.nfts.CompareAndUpsertFields(tkn, newtkn, mask) }
+	}
+	if err := write(); errors.Is(err, nft.ErrConflict) && im == "" {
+		// The token changed since it was authorized, e.g., by a transfer.
+		writeError(w, http.StatusConflict, ErrorResponse{Code: nft.CodeConflict, Message: err.Error()})
+		return nft.NFT{}, false
+	} else if err != nil {
+		storageError(w, "Error updating token: ", err)
+		return nft.NFT{}, false
+	}
+
+	updated, err := s.nfts.Get(newtkn.Token, newtkn.ID)
+	if err != nil {
+		httpError(w, "Error reading updated token: "+err.Error(), http.StatusInternalServerError)
+		return nft.NFT{}, false
+	} else if tag, err := etag(updated); err != nil {
+		log.Errorf("Error computing ETag of %v: %v", updated, err)
+	} else {
+		w.Header().Set("ETag", tag)
+	}
+	return updated, true
+}
+
+// handleGETnftRevisions returns all revisions of the NFT. Secret revisions are
+// redacted unless the request is signed by the owner.
+func (s *Server) handleGETnftRevisions(w http.ResponseWriter, r *http.Request) {
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		viewer, ok := s.viewer(w, r)
+		if !ok {
+			return
+		}
+		revs, err := s.nfts.Revisions(tkn.Token, tkn.ID)
+		if err != nil {
+			storageError(w, "", err)
+			return
+		}
+		if revs == nil {
+			revs = []nft.Revision{} // encode as empty JSON array instead of null
+		}
+		for i := range revs {
+			if !revs[i].Secret {
+				continue
+			}
+			setPrivate(w)
+			if !isOwner(viewer, tkn) {
+				revs[i] = revs[i].Redacted()
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(revs); err != nil {
+			log.Errorf("Error JSON-marshalling revisions of token %v: %v", tkn, err)
+		}
+	})
+}
+
+// handleGETnftRevision returns a revision of the NFT. A secret revision is
+// redacted unless the request is signed by the owner.
+func (s *Server) handleGETnftRevision(w http.ResponseWriter, r *http.Request) {
+	rev, ok := readRev(w, r)
+	if !ok {
+		return
+	}
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		viewer, ok := s.viewer(w, r)
+		if !ok {
+			return
+		}
+		revision, err := s.nfts.Revision(tkn.Token, tkn.ID, rev)
+		if err != nil {
+			storageError(w, "", err)
+			return
+		}
+		if revision.Secret {
+			setPrivate(w)
+		}
+		if !isOwner(viewer, tkn) {
+			revision = revision.Redacted()
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(revision); err != nil {
+			log.Errorf("Error JSON-marshalling revision %d of token %v: %v", rev, tkn, err)
+		}
+	})
+}
 
-	s.nfts.Upsert(newtkn)
+// handlePOSTnftRollback rolls the NFT metadata back to a revision. The request
+// must be signed by the NFT's owner, see rollbackPayload, and the restored
+// metadata is checked like on PUT, see updateNFT. The updated NFT is returned.
+func (s *Server) handlePOSTnftRollback(w http.ResponseWriter, r *http.Request) {
+	token, id := mustReadTokenID(r)
+	rev, ok := readRev(w, r)
+	if !ok {
+		return
+	}
+	s.handleNFTRequest(w, r, func(tkn nft.NFT) {
+		target, err := s.nfts.Revision(token, id, rev)
+		if err != nil {
+			storageError(w, "", err)
+			return
+		}
+		// The rolled back NFT goes through the same checks as other updates.
+		newtkn := nft.NFT{Token: token, ID: id, AssetID: target.AssetID, Secret: target.Secret,
+			Title: target.Title, Desc: target.Desc, Attributes: target.Attributes}
+		mask := nft.FieldMask{nft.FieldAssetID, nft.FieldSecret, nft.FieldTitle, nft.FieldDesc, nft.FieldAttributes}
+		if !s.validateNFT(w, newtkn) {
+			return
+		}
+		payload := rollbackPayload{Token: token, ID: id.Text(10), Rev: rev}
+		if !s.authorizeOwner(w, r, tkn.Owner, ActionRollback, payload) {
+			return
+		}
+		updated, ok := s.updateNFT(w, r, tkn, newtkn, mask, func() error {
+			_, err := s.nfts.CompareAndRollback(tkn, rev)
+			return err
+		})
+		if !ok {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		if err := json.NewEncoder(w).Encode(updated); err != nil {
+			log.Errorf("Error JSON-marshalling %v: %v", updated, err)
+		}
+	})
+}
+
+// isOwner reports whether viewer is the owner of tkn. The zero address is
+// never considered the owner.
+func isOwner(viewer common.Address, tkn nft.NFT) bool {
+	return viewer != eth.Zero && viewer == tkn.Owner
+}
+
+// checkOwner checks that the owner in an update of the existing NFT tkn is
+// either not set or matches tkn's owner. Otherwise, it responds with an error
+// and returns false.
+func checkOwner(w http.ResponseWriter, tkn nft.NFT, owner common.Address) bool {
+	if owner != eth.Zero && tkn.Owner != owner {
+		httpError(w, fmt.Sprintf("Existing token has different owner: %v", tkn), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// readRev reads the revision number from the request URL. If it is invalid, it
+// responds with an error and returns false.
+func readRev(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	rev, err := strconv.ParseUint(mux.Vars(r)["rev"], 10, 64)
+	if err != nil { // only possible on overflow due to regexp
+		httpError(w, "Invalid revision: "+err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+	return rev, true
+}
+
+func mustReadToken(r *http.Request) common.Address {
+	return common.HexToAddress(mux.Vars(r)["token"]) // valid due to regexp
 }
 
 func mustReadTokenID(r *http.Request) (common.Address, *big.Int) {
@@ -189,8 +597,3 @@ func mustReadTokenID(r *http.Request) (common.Address, *big.Int) {
 	)
 	return token, id
 }
-
-func httpError(w http.ResponseWriter, err string, code int) {
-	log.Debugf("Responding error [%d] %v", code, err)
-	http.Error(w, err, code)
-}
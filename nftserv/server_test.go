@@ -3,12 +3,18 @@
 package nftserv_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,6 +25,8 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	ptest "perun.network/go-perun/pkg/test"
@@ -44,21 +52,28 @@ func TestServer(t *testing.T) {
 		require             = require.New(t)
 		rng                 = ptest.Prng(t)
 		nfts                = nft.NewMemory()
+		storage             = &transferringStorage{Storage: nfts}
 		assetsDir           = createTmpAssetsDir(t, ext, 0, 1, 420)
 		assets, _           = asset.NewFileStorage(assetsDir)
+		adminKey, _         = ecdsa.GenerateKey(crypto.S256(), rng)
 		defaultServerConfig = nftserv.ServerConfig{
 			Host:           host,
 			Port:           port,
 			MaxPayloadSize: 1024,
+			MaxAssetSize:   16,
+			ChainID:        1337,
+			PublicURL:      "https://nft.example.com/",
+			ExternalURL:    "https://nerd.example.com/nft",
+			SIWEDomain:     fmt.Sprintf("%s:%d", host, port),
+
+			CollectionAdmin: crypto.PubkeyToAddress(adminKey.PublicKey),
 		}
-		srv        = nftserv.New(nfts, assets, defaultServerConfig)
-		owner, acc = randomAccount(rng, 5)
-		tv         = acc.Values.OrderedValues()[0]
-		ids        = value.MustAsBigInts(tv.Value)
-		srverr     = make(chan error, 1)
+		srv             = nftserv.New(storage, assets, defaultServerConfig)
+		key, owner, acc = randomAccount(rng, 5)
+		tv              = acc.Values.OrderedValues()[0]
+		ids             = value.MustAsBigInts(tv.Value)
+		srverr          = make(chan error, 1)
 	)
-	assets.SetExtension(ext)
-
 	go func() {
 		srverr <- srv.Serve()
 	}()
@@ -100,9 +115,19 @@ func TestServer(t *testing.T) {
 		require.False(tkn.Secret)
 	}
 
+	// GET /nft/.../history
+	resp, err := http.Get(url("nft", tv.Token.String(), ids[0], "history"))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	var hist []nft.OwnerChange
+	require.NoError(json.NewDecoder(resp.Body).Decode(&hist))
+	require.Len(hist, 1)
+	require.Equal(eth.Zero, hist[0].From)
+	require.Equal(owner, hist[0].To)
+
 	// GET /nfts
 	tkns := nft.Extract(owner, acc)
-	resp, err := http.Get(url("nfts"))
+	resp, err = http.Get(url("nfts"))
 	require.NoError(err)
 	requireStatus(t, resp, http.StatusOK)
 	var getnfts []nft.NFT
@@ -110,16 +135,37 @@ func TestServer(t *testing.T) {
 	require.Len(getnfts, len(tkns))
 	require.ElementsMatch(tkns, getnfts)
 
+	// GET /nfts with filter and pagination
+	var paged []nft.NFT
+	for cursor := ""; ; {
+		resp, err := http.Get(url("nfts") + "?owner=" + owner.String() + "&limit=2&cursor=" + cursor)
+		require.NoError(err)
+		requireStatus(t, resp, http.StatusOK)
+		var page []nft.NFT
+		require.NoError(json.NewDecoder(resp.Body).Decode(&page))
+		require.LessOrEqual(len(page), 2)
+		paged = append(paged, page...)
+		if cursor = resp.Header.Get(nftserv.NextCursorHeader); cursor == "" {
+			break
+		}
+	}
+	require.ElementsMatch(tkns, paged)
+
 	// invalid requests
-	expectError := func(geturl string, code int) {
+	expectError := func(geturl string, status int, code string) {
 		resp, err := http.Get(geturl)
 		require.NoError(err)
-		requireStatus(t, resp, code)
+		requireError(t, resp, status, code)
 	}
 
-	expectError(url("foo"), http.StatusNotFound)
-	expectError(url("nft", eth.NewRandomAddress(rng).String(), ids[0]), http.StatusNotFound)
-	expectError(url("nft", tv.Token.String(), rng.Int()), http.StatusNotFound)
+	expectError(url("foo"), http.StatusNotFound, nftserv.CodeNotFound)
+	expectError(url("nfts")+"?owner=0xfoo", http.StatusBadRequest, nftserv.CodeBadRequest)
+	expectError(url("nfts")+"?limit=0", http.StatusBadRequest, nftserv.CodeBadRequest)
+	expectError(url("nft", eth.NewRandomAddress(rng).String(), ids[0]), http.StatusNotFound, nft.CodeNotFound)
+	expectError(url("nft", tv.Token.String(), rng.Int()), http.StatusNotFound, nft.CodeNotFound)
+	resp, err = sendAsJSON(http.MethodDelete, url("nft", tv.Token.String(), ids[0]), nil, nil)
+	require.NoError(err)
+	requireError(t, resp, http.StatusMethodNotAllowed, nftserv.CodeMethodNotAllowed)
 
 	// GET /nft/.../asset
 	expectAsset := func(token common.Address, id *big.Int, assetId uint) {
@@ -132,20 +178,760 @@ func TestServer(t *testing.T) {
 	}
 
 	tkn := &tkns[0]
-	expectAsset(tkn.Token, tkn.ID, 0)
+	// asset 0 exists in the assets folder, but the reserved id means no asset
+	expectError(url("nft", tkn.Token.String(), tkn.ID, "asset"), http.StatusNotFound, asset.CodeNotFound)
 
 	// PUT /nft/...
 	tkn.AssetID = 420
 	resp, err = putAsJSON(url("nft", tkn.Token.String(), tkn.ID), tkn)
 	require.NoError(err)
+	requireStatus(t, resp, http.StatusUnauthorized)
+	otherKey, _ := ecdsa.GenerateKey(crypto.S256(), rng)
+	resp, err = putSigned(t, otherKey, *tkn, nil)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusForbidden)
+	// requests of others don't use up the owner's nonce
+	nonce := getNonce(t)
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn,
+		signWithNonce(t, otherKey, nftserv.ActionUpdate, updatePayload(*tkn), nonce))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusForbidden)
+	header := signWithNonce(t, key, nftserv.ActionUpdate, updatePayload(*tkn), nonce)
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, header)
+	require.NoError(err)
 	requireStatus(t, resp, http.StatusOK)
 	expectAsset(tkn.Token, tkn.ID, 420)
+	// but nonces can only be used once
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, header)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusUnauthorized)
+	// and must be issued by the server
+	forged := []byte(getNonce(t))
+	forged[len(forged)-1] ^= 1
+	resp, err = putSigned(t, key, *tkn, http.Header{nftserv.NonceHeader: []string{string(forged)}})
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusUnauthorized)
+
+	// range, conditional and HEAD requests on assets
+	assetURL := url("nft", tkn.Token.String(), tkn.ID, "asset")
+	resp = getWithHeader(t, assetURL, http.Header{"Range": []string{"bytes=1-"}})
+	requireStatus(t, resp, http.StatusPartialContent)
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal("20", string(data))
+	assetTag := resp.Header.Get("ETag")
+	require.NotEmpty(assetTag)
+	requireStatus(t, getWithHeader(t, assetURL, http.Header{"If-None-Match": []string{assetTag}}),
+		http.StatusNotModified)
+	requireError(t, getWithHeader(t, assetURL, http.Header{"Range": []string{"bytes=10-"}}),
+		http.StatusRequestedRangeNotSatisfiable, nftserv.CodeBadRequest)
+	resp, err = http.Head(assetURL)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	require.EqualValues(3, resp.ContentLength)
+
+	// ERC-721 metadata
+	resp, err = http.Get(url("metadata", tkn.Token.String(), tkn.ID))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	var md nftserv.Metadata
+	require.NoError(json.NewDecoder(resp.Body).Decode(&md))
+	require.Equal("#"+tkn.ID.String(), md.Name)
+	require.Equal(fmt.Sprintf("https://nft.example.com/nft/%s/%s/asset", tkn.Token.Hex(), tkn.ID), md.Image)
+	require.Equal(fmt.Sprintf("https://nerd.example.com/nft/%s/%s", tkn.Token.Hex(), tkn.ID), md.ExternalURL)
+	require.NotNil(md.Attributes)
+
+	// replayed nonce
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, header)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusUnauthorized)
+
+	// EIP-712 signed PUT
+	putTypedData := func(deadline time.Time) *http.Response {
+		nonce := getNonce(t)
+		upd, err := nftserv.NewNFTUpdate(*tkn, nonce, uint64(deadline.Unix()))
+		require.NoError(err)
+		sig, err := crypto.Sign(upd.TypedDataHash(big.NewInt(1337)), key)
+		require.NoError(err)
+		header := http.Header{}
+		header.Set(nftserv.NonceHeader, nonce)
+		header.Set(nftserv.SignatureHeader, hexutil.Encode(sig))
+		header.Set(nftserv.SignatureSchemeHeader, nftserv.SchemeTypedData)
+		header.Set(nftserv.DeadlineHeader, strconv.FormatInt(deadline.Unix(), 10))
+		resp, err := sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, header)
+		require.NoError(err)
+		return resp
+	}
+	tkn.AssetID = 1
+	requireStatus(t, putTypedData(time.Now().Add(-time.Minute)), http.StatusUnauthorized)
+	requireStatus(t, putTypedData(time.Now().Add(time.Minute)), http.StatusOK)
+	expectAsset(tkn.Token, tkn.ID, 1)
+	tkn.AssetID = 420
+	resp, err = putSigned(t, key, *tkn, nil)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+
+	// revisions and rollback
+	tkn.Title = "Fancy Title"
+	resp, err = putSigned(t, key, *tkn, nil)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	expectRevisions := func(n int) []nft.Revision {
+		resp, err := http.Get(url("nft", tkn.Token.String(), tkn.ID, "revisions"))
+		require.NoError(err)
+		requireStatus(t, resp, http.StatusOK)
+		var revs []nft.Revision
+		require.NoError(json.NewDecoder(resp.Body).Decode(&revs))
+		require.Len(revs, n)
+		return revs
+	}
+	revs := expectRevisions(4)
+	require.Equal(uint64(4), revs[3].Rev)
+	require.Equal(tkn.Title, revs[3].Title)
+	resp, err = http.Get(url("nft", tkn.Token.String(), tkn.ID, "revisions", 5))
+	require.NoError(err)
+	requireError(t, resp, http.StatusNotFound, nft.CodeRevisionNotFound)
+
+	rollbackURL := url("nft", tkn.Token.String(), tkn.ID, "revisions", 1, "rollback")
+	rollback := rollbackPayload{Token: tkn.Token, ID: tkn.ID.String(), Rev: 1}
+	resp, err = sendAsJSON(http.MethodPost, rollbackURL, nil,
+		signedHeader(t, otherKey, nftserv.ActionRollback, rollback))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusForbidden)
+	// rollbacks are checked like other updates
+	staleHeader := signedHeader(t, key, nftserv.ActionRollback, rollback)
+	staleHeader.Set("If-Match", `"stale"`)
+	resp, err = sendAsJSON(http.MethodPost, rollbackURL, nil, staleHeader)
+	require.NoError(err)
+	requireError(t, resp, http.StatusPreconditionFailed, nft.CodeConflict)
+	storage.tkn, storage.after = nft.NFT{Token: tkn.Token, ID: tkn.ID, Owner: eth.NewRandomAddress(rng)}, "Get"
+	resp, err = sendAsJSON(http.MethodPost, rollbackURL, nil,
+		signedHeader(t, key, nftserv.ActionRollback, rollback))
+	require.NoError(err)
+	requireError(t, resp, http.StatusConflict, nft.CodeConflict)
+	require.NoError(nfts.Upsert(nft.NFT{Token: tkn.Token, ID: tkn.ID, Owner: owner}))
+	expectRevisions(4)
+
+	resp, err = sendAsJSON(http.MethodPost, rollbackURL, nil,
+		signedHeader(t, key, nftserv.ActionRollback, rollback))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	require.NotEmpty(resp.Header.Get("ETag"))
+	var rolledBack nft.NFT
+	require.NoError(json.NewDecoder(resp.Body).Decode(&rolledBack))
+	require.Empty(rolledBack.Title)
+	require.Equal(uint(420), rolledBack.AssetID)
+	revs = expectRevisions(5)
+	require.Equal(uint64(5), revs[4].Rev)
+	require.Empty(revs[4].Title)
+	tkn.Title = ""
+
+	// optimistic concurrency with ETags
+	resp, err = http.Get(url("nft", tkn.Token.String(), tkn.ID))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	tag := resp.Header.Get("ETag")
+	require.NotEmpty(tag)
+	ifMatch := func(tag string) http.Header { return http.Header{"If-Match": []string{tag}} }
+	tkn.Desc = "first tab"
+	resp, err = putSigned(t, key, *tkn, ifMatch(tag))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	newTag := resp.Header.Get("ETag")
+	require.NotEqual(tag, newTag)
+	tkn.Desc = "second tab"
+	resp, err = putSigned(t, key, *tkn, ifMatch(tag))
+	require.NoError(err)
+	requireError(t, resp, http.StatusPreconditionFailed, nft.CodeConflict)
+	tkn.Desc = "first tab"
 
 	tkn.Title = strings.Repeat("pay_respect", 25)
 	tkn.Desc = strings.Repeat("fubar", 210)
-	resp, err = putAsJSON(url("nft", tkn.Token.String(), tkn.ID), tkn)
+	resp, err = putSigned(t, key, *tkn, nil)
 	require.NoError(err)
 	requireStatus(t, resp, http.StatusRequestEntityTooLarge)
+
+	// secret NFTs
+	tkn.Title, tkn.Desc, tkn.Secret = "secret title", "secret desc", true
+	resp, err = putSigned(t, key, *tkn, nil)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	getNFT := func(header http.Header) nft.NFT {
+		resp := getWithHeader(t, url("nft", tkn.Token.String(), tkn.ID), header)
+		requireStatus(t, resp, http.StatusOK)
+		var got nft.NFT
+		require.NoError(json.NewDecoder(resp.Body).Decode(&got))
+		return got
+	}
+	redacted := getNFT(nil)
+	require.True(redacted.Secret)
+	require.Empty(redacted.Title)
+	require.Empty(redacted.Desc)
+	require.Zero(redacted.AssetID)
+	require.Equal(*tkn, getNFT(readHeader(t, key, "nft", tkn.Token.String(), tkn.ID)))
+	require.Empty(getNFT(readHeader(t, otherKey, "nft", tkn.Token.String(), tkn.ID)).Title)
+
+	// responses that depend on the authentication aren't cached for others
+	requirePrivate := func(resp *http.Response, private bool) {
+		t.Helper()
+		requireStatus(t, resp, http.StatusOK)
+		if !private {
+			require.Empty(resp.Header.Get("Cache-Control"))
+			return
+		}
+		require.Equal("private", resp.Header.Get("Cache-Control"))
+		require.Equal("X-Signature, Authorization", resp.Header.Get("Vary"))
+	}
+	secretRevs, err := nfts.Revisions(tkn.Token, tkn.ID)
+	require.NoError(err)
+	for _, path := range [][]interface{}{
+		{"nft", tkn.Token.String(), tkn.ID},
+		{"metadata", tkn.Token.String(), tkn.ID},
+		{"nft", tkn.Token.String(), tkn.ID, "revisions"},
+		{"nft", tkn.Token.String(), tkn.ID, "revisions", secretRevs[len(secretRevs)-1].Rev},
+		{"nfts"},
+	} {
+		requirePrivate(getWithHeader(t, url(path...), nil), true)
+	}
+	requirePrivate(getWithHeader(t, url("nft", tkn.Token.String(), tkn.ID, "revisions", 1), nil), false)
+	requirePrivate(getWithHeader(t, url("nft", tv.Token.String(), ids[1]), nil), false)
+	requirePrivate(getWithHeader(t, url("metadata", tv.Token.String(), ids[1]), nil), false)
+	requirePrivate(getWithHeader(t, assetURL, readHeader(t, key, "nft", tkn.Token.String(), tkn.ID, "asset")), true)
+
+	requireStatus(t, getWithHeader(t, assetURL, nil), http.StatusUnauthorized)
+	requireStatus(t, getWithHeader(t, assetURL,
+		readHeader(t, otherKey, "nft", tkn.Token.String(), tkn.ID, "asset")), http.StatusForbidden)
+	resp = getWithHeader(t, assetURL, readHeader(t, key, "nft", tkn.Token.String(), tkn.ID, "asset"))
+	requireStatus(t, resp, http.StatusOK)
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal("420", string(data))
+
+	// the secret asset can't be published with an NFT of another owner
+	otherAddr := crypto.PubkeyToAddress(otherKey.PublicKey)
+	otherTkn := nft.NFT{Token: eth.NewRandomAddress(rng), ID: big.NewInt(1), Owner: otherAddr}
+	srv.UpdateBalance(otherAddr, tee.Account{Values: value.TokenValues(otherTkn.Token, &value.IDSet{otherTkn.ID})})
+	otherTkn.AssetID = tkn.AssetID
+	resp, err = putSigned(t, otherKey, otherTkn, nil)
+	require.NoError(err)
+	requireError(t, resp, http.StatusForbidden, nftserv.CodeForbidden)
+	resp = getWithHeader(t, url("nft", otherTkn.Token.String(), otherTkn.ID), nil)
+	requireStatus(t, resp, http.StatusOK)
+	var stolen nft.NFT
+	require.NoError(json.NewDecoder(resp.Body).Decode(&stolen))
+	require.Zero(stolen.AssetID)
+
+	// updates of the previous owner don't overwrite concurrent transfers
+	moved := nft.NFT{Token: tv.Token, ID: ids[2], Owner: owner, Title: "stale"}
+	storage.tkn, storage.after = nft.NFT{Token: moved.Token, ID: moved.ID, Owner: otherAddr}, "Get"
+	resp, err = putSigned(t, key, moved, nil)
+	require.NoError(err)
+	requireError(t, resp, http.StatusConflict, nft.CodeConflict)
+	got, err := nfts.Get(moved.Token, moved.ID)
+	require.NoError(err)
+	require.Equal(otherAddr, got.Owner)
+	require.Empty(got.Title)
+	require.NoError(nfts.Upsert(nft.NFT{Token: moved.Token, ID: moved.ID, Owner: owner}))
+
+	resp = getWithHeader(t, url("nfts")+"?secret=true", nil)
+	requireStatus(t, resp, http.StatusOK)
+	var secrets []nft.NFT
+	require.NoError(json.NewDecoder(resp.Body).Decode(&secrets))
+	require.Len(secrets, 1)
+	require.Empty(secrets[0].Title)
+
+	// Sign-In with Ethereum sessions
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+	session := login(t, key, owner)
+	// the domain is configured, not taken from the Host header
+	requireStatus(t, sendLogin(t, key, owner, "evil.example.com"), http.StatusUnauthorized)
+	tkn.Desc = "session desc"
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	require.Equal(*tkn, getNFT(bearer(session)))
+	requireStatus(t, getWithHeader(t, assetURL, bearer(session)), http.StatusOK)
+	requireStatus(t, getWithHeader(t, assetURL, bearer(login(t, otherKey, crypto.PubkeyToAddress(otherKey.PublicKey)))),
+		http.StatusForbidden)
+	requireStatus(t, getWithHeader(t, assetURL, bearer(session+"x")), http.StatusUnauthorized)
+
+	// asset uploads
+	upload := func(data string, header http.Header) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, url("assets"), strings.NewReader(data))
+		require.NoError(err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := new(http.Client).Do(req)
+		require.NoError(err)
+		return resp
+	}
+	requireStatus(t, upload("new asset", nil), http.StatusUnauthorized)
+	requireStatus(t, upload(strings.Repeat("x", 17), bearer(session)), http.StatusRequestEntityTooLarge)
+	requireError(t, upload("new asset", signedHeader(t, adminKey, nftserv.ActionUpload, map[string]string{"path": "/assets"})),
+		http.StatusForbidden, nftserv.CodeForbidden)
+	uploadHeader := signedHeader(t, otherKey, nftserv.ActionUpload, map[string]string{"path": "/assets"})
+	uploadHeader.Set("Content-Type", "text/html")
+	requireError(t, upload("new asset", uploadHeader), http.StatusUnsupportedMediaType, nftserv.CodeUnsupportedMediaType)
+	uploadHeader.Set("Content-Type", "image/png")
+	resp = upload("new asset", uploadHeader)
+	requireStatus(t, resp, http.StatusOK)
+	var uploaded struct {
+		AssetID uint
+		SHA256  string
+	}
+	require.NoError(json.NewDecoder(resp.Body).Decode(&uploaded))
+	require.Equal(uint(421), uploaded.AssetID)
+	sum := sha256.Sum256([]byte("new asset"))
+	require.Equal(hex.EncodeToString(sum[:]), uploaded.SHA256)
+	// only uploaders can use an uploaded asset
+	tkn.AssetID = uploaded.AssetID
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, bearer(session))
+	require.NoError(err)
+	requireError(t, resp, http.StatusForbidden, nftserv.CodeForbidden)
+	sessionUploadHeader := bearer(session)
+	sessionUploadHeader.Set("Content-Type", "image/png")
+	resp = upload("new asset", sessionUploadHeader)
+	requireStatus(t, resp, http.StatusOK)
+	var dup struct{ AssetID uint }
+	require.NoError(json.NewDecoder(resp.Body).Decode(&dup))
+	require.Equal(uploaded.AssetID, dup.AssetID)
+
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	resp = getWithHeader(t, assetURL, bearer(session))
+	requireStatus(t, resp, http.StatusOK)
+	data, err = io.ReadAll(resp.Body)
+	require.NoError(err)
+	require.Equal("new asset", string(data))
+	require.Equal("image/png", resp.Header.Get("Content-Type"))
+	require.Equal("nosniff", resp.Header.Get("X-Content-Type-Options"))
+	require.Equal("sandbox", resp.Header.Get("Content-Security-Policy"))
+	require.Empty(resp.Header.Get("Content-Disposition"))
+
+	// untyped uploads are detected from their content
+	requireError(t, upload("<html>", bearer(session)), http.StatusUnsupportedMediaType, nftserv.CodeUnsupportedMediaType)
+	png := "\x89PNG\x0D\x0A\x1A\x0A"
+	resp = upload(png, bearer(session))
+	requireStatus(t, resp, http.StatusOK)
+	var sniffed struct{ AssetID uint }
+	require.NoError(json.NewDecoder(resp.Body).Decode(&sniffed))
+	sniffedTkn := nft.NFT{Token: tv.Token, ID: ids[1], Owner: owner, AssetID: sniffed.AssetID}
+	resp, err = sendAsJSON(http.MethodPut, url("nft", sniffedTkn.Token.String(), sniffedTkn.ID), sniffedTkn, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	resp = getWithHeader(t, url("nft", sniffedTkn.Token.String(), sniffedTkn.ID, "asset"), nil)
+	requireStatus(t, resp, http.StatusOK)
+	require.Equal("image/png", resp.Header.Get("Content-Type"))
+
+	// assets detected as HTML, which can only be added to the assets folder
+	// directly, are downloaded, not rendered
+	require.NoError(os.WriteFile(filepath.Join(assetsDir, "2.html"), []byte("<html>"), 0666))
+	assets.SetReindexInterval(0)
+	htmlTkn := nft.NFT{Token: tv.Token, ID: ids[1], Owner: owner, AssetID: 2}
+	resp, err = sendAsJSON(http.MethodPut, url("nft", htmlTkn.Token.String(), htmlTkn.ID), htmlTkn, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	resp = getWithHeader(t, url("nft", htmlTkn.Token.String(), htmlTkn.ID, "asset"), nil)
+	requireStatus(t, resp, http.StatusOK)
+	require.Equal("text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	require.Equal("sandbox", resp.Header.Get("Content-Security-Policy"))
+	require.Equal("attachment", resp.Header.Get("Content-Disposition"))
+
+	// trait attributes
+	tkn.Attributes = []nft.Attribute{{TraitType: "Color", Value: "Blue"}, {TraitType: "Color", Value: "Red"}}
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusBadRequest)
+	tkn.Attributes = []nft.Attribute{{TraitType: "Color", Value: "Blue"}, {TraitType: "Level", Value: 3.0}}
+	requireStatus(t, putTypedData(time.Now().Add(time.Minute)), http.StatusOK)
+	require.Equal(*tkn, getNFT(bearer(session)))
+	searchTraits := func(header http.Header) []nft.NFT {
+		resp := getWithHeader(t, url("nfts")+"?trait=Color:Blue&trait=Level:3", header)
+		requireStatus(t, resp, http.StatusOK)
+		var found []nft.NFT
+		require.NoError(json.NewDecoder(resp.Body).Decode(&found))
+		return found
+	}
+	require.Equal([]nft.NFT{*tkn}, searchTraits(bearer(session)))
+	require.Empty(searchTraits(nil), "secret attributes not searchable")
+
+	// JSON Merge Patch
+	nftURL := url("nft", tkn.Token.String(), tkn.ID)
+	resp, err = sendAsJSON(http.MethodPatch, nftURL, map[string]bool{"secret": false}, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusUnsupportedMediaType)
+	requireStatus(t, sendPatch(t, nftURL, `{"state":"withdrawn"}`, bearer(session)), http.StatusBadRequest)
+	mergePatch := `{"secret":false,"desc":null,"title":"Tom & Jerry"}`
+	requireStatus(t, sendPatch(t, nftURL, mergePatch, signedHeader(t, adminKey, nftserv.ActionPatch, json.RawMessage(mergePatch))), http.StatusForbidden)
+	resp = sendPatch(t, nftURL, mergePatch, signedHeader(t, key, nftserv.ActionPatch, json.RawMessage(mergePatch)))
+	requireStatus(t, resp, http.StatusOK)
+	tkn.Secret, tkn.Desc, tkn.Title = false, "", "Tom & Jerry"
+	var patched nft.NFT
+	require.NoError(json.NewDecoder(resp.Body).Decode(&patched))
+	require.Equal(*tkn, patched)
+	require.Equal(*tkn, getNFT(nil), "no longer secret")
+	// payloads are signed without escaping HTML characters
+	tkn.Title = "Tom & Jerry <3>"
+	resp, err = putSigned(t, key, *tkn, nil)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	require.Equal(*tkn, getNFT(nil))
+
+	// field validation
+	invalid := *tkn
+	invalid.AssetID, invalid.Title = 999, "bad\x00title"
+	resp, err = sendAsJSON(http.MethodPut, nftURL, invalid, bearer(session))
+	require.NoError(err)
+	invalidResp := requireError(t, resp, http.StatusBadRequest, nft.CodeInvalid)
+	require.Len(invalidResp.Fields, 2)
+	require.ElementsMatch([]string{nft.FieldAssetID, nft.FieldTitle},
+		[]string{invalidResp.Fields[0].Field, invalidResp.Fields[1].Field})
+	requireStatus(t, sendPatch(t, nftURL, `{"desc":"\u0007"}`, bearer(session)), http.StatusBadRequest)
+	// bodies without Content-Length are limited, too
+	req, err := http.NewRequest(http.MethodPut, nftURL, io.MultiReader(
+		strings.NewReader(`{"title":"`), strings.NewReader(strings.Repeat("a", 2048))))
+	require.NoError(err)
+	for k, v := range bearer(session) {
+		req.Header[k] = v
+	}
+	resp, err = new(http.Client).Do(req)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusRequestEntityTooLarge)
+	// payloads of exactly the limit are accepted, with and without
+	// Content-Length
+	descPatch, err := json.Marshal(map[string]string{"desc": tkn.Desc})
+	require.NoError(err)
+	maxPatch := string(descPatch) + strings.Repeat(" ", defaultServerConfig.MaxPayloadSize-len(descPatch))
+	requireStatus(t, sendPatch(t, nftURL, maxPatch, bearer(session)), http.StatusOK)
+	requireStatus(t, sendPatch(t, nftURL, maxPatch+" ", bearer(session)), http.StatusRequestEntityTooLarge)
+	req, err = http.NewRequest(http.MethodPatch, nftURL, io.MultiReader(strings.NewReader(maxPatch)))
+	require.NoError(err)
+	for k, v := range bearer(session) {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	resp, err = new(http.Client).Do(req)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+
+	// collection metadata
+	collURL := url("collection", tv.Token.String())
+	requireStatus(t, getWithHeader(t, collURL, nil), http.StatusNotFound)
+	coll := nft.Collection{
+		Token:   tv.Token,
+		Name:    "Nerds",
+		Symbol:  "NERD",
+		Creator: owner,
+		Royalty: &nft.Royalty{Receiver: owner, BasisPoints: 10001},
+	}
+	resp, err = sendAsJSON(http.MethodPut, collURL, coll, signedHeader(t, adminKey, nftserv.ActionCollection, coll))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusBadRequest)
+	coll.Royalty.BasisPoints = 500
+	resp, err = sendAsJSON(http.MethodPut, collURL, coll, nil)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusUnauthorized)
+	resp, err = sendAsJSON(http.MethodPut, collURL, coll, signedHeader(t, key, nftserv.ActionCollection, coll))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusForbidden)
+	resp, err = sendAsJSON(http.MethodPut, collURL, coll, signedHeader(t, adminKey, nftserv.ActionCollection, coll))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	resp = getWithHeader(t, collURL, nil)
+	requireStatus(t, resp, http.StatusOK)
+	var gotColl nft.Collection
+	require.NoError(json.NewDecoder(resp.Body).Decode(&gotColl))
+	require.Equal(coll, gotColl)
+
+	// EIP-2981 royalty info
+	royaltyURL := url("nft", tkn.Token.String(), tkn.ID, "royalty")
+	royaltyInfo := func(salePrice string) (common.Address, string) {
+		resp := getWithHeader(t, royaltyURL+"?salePrice="+salePrice, nil)
+		requireStatus(t, resp, http.StatusOK)
+		var info struct {
+			Receiver      common.Address
+			RoyaltyAmount string
+		}
+		require.NoError(json.NewDecoder(resp.Body).Decode(&info))
+		return info.Receiver, info.RoyaltyAmount
+	}
+	receiver, amount := royaltyInfo("1000001")
+	require.Equal(owner, receiver)
+	require.Equal("50000", amount)
+	requireStatus(t, getWithHeader(t, royaltyURL+"?salePrice=-1", nil), http.StatusBadRequest)
+	requireStatus(t, getWithHeader(t, royaltyURL+"?salePrice="+new(big.Int).Lsh(big.NewInt(1), 255).String(), nil), http.StatusBadRequest)
+	// owners can't override the royalty
+	tkn.Royalty = &nft.Royalty{Receiver: owner, BasisPoints: 10000}
+	resp, err = sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, bearer(session))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	_, amount = royaltyInfo("100")
+	require.Equal("5", amount)
+	// but the collection admin can
+	override := nft.Royalty{Receiver: crypto.PubkeyToAddress(adminKey.PublicKey), BasisPoints: 250}
+	overridePayload := royaltyPayload{Token: tkn.Token, ID: tkn.ID.String(), Royalty: &override}
+	resp, err = sendAsJSON(http.MethodPut, royaltyURL, override, signedHeader(t, key, nftserv.ActionRoyalty, overridePayload))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusForbidden)
+	resp, err = sendAsJSON(http.MethodPut, royaltyURL, override, signedHeader(t, adminKey, nftserv.ActionRoyalty, overridePayload))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	receiver, amount = royaltyInfo("100")
+	require.Equal(override.Receiver, receiver)
+	require.Equal("2", amount)
+	tkn.Royalty = &override
+	require.Equal(*tkn, getNFT(bearer(session)))
+	resp = getWithHeader(t, url("metadata", tkn.Token.String(), tkn.ID), bearer(session))
+	requireStatus(t, resp, http.StatusOK)
+	md = nftserv.Metadata{}
+	require.NoError(json.NewDecoder(resp.Body).Decode(&md))
+	require.Equal(&override, md.Royalty)
+	// and remove the override again
+	removePayload := royaltyPayload{Token: tkn.Token, ID: tkn.ID.String()}
+	resp, err = sendAsJSON(http.MethodDelete, royaltyURL, nil, signedHeader(t, key, nftserv.ActionRoyalty, removePayload))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusForbidden)
+	resp, err = sendAsJSON(http.MethodDelete, royaltyURL, nil, signedHeader(t, adminKey, nftserv.ActionRoyalty, removePayload))
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	_, amount = royaltyInfo("100")
+	require.Equal("5", amount)
+	tkn.Royalty = nil
+	require.Equal(*tkn, getNFT(bearer(session)))
+
+	// NFTs leaving the account are marked as withdrawn
+	goneID, kept := ids[len(ids)-1], value.IDSet(ids[:len(ids)-1])
+	acc.Values[tv.Token] = &kept
+	srv.UpdateBalance(owner, acc)
+	expectState := func(id *big.Int, state nft.State) {
+		resp, err := http.Get(url("nft", tv.Token.String(), id))
+		require.NoError(err)
+		requireStatus(t, resp, http.StatusOK)
+		var tkn nft.NFT
+		require.NoError(json.NewDecoder(resp.Body).Decode(&tkn))
+		require.Equal(owner, tkn.Owner)
+		require.Equal(state, tkn.State)
+	}
+	expectState(goneID, nft.StateWithdrawn)
+	expectState(ids[0], nft.StateOwned)
+
+	// graceful shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(srv.Shutdown(ctx))
+	require.ErrorIs(<-srverr, http.ErrServerClosed)
+	_, err = http.Get(url("status"))
+	require.Error(err)
+	// balance updates are ignored after shutdown
+	_, lateOwner, lateAcc := randomAccount(rng, 1)
+	srv.UpdateBalance(lateOwner, lateAcc)
+	late, err := nfts.GetByOwner(lateOwner)
+	require.NoError(err)
+	require.Empty(late)
+}
+
+func TestServerConcurrentTransfer(t *testing.T) {
+	var (
+		require        = require.New(t)
+		rng            = ptest.Prng(t)
+		assets, _      = asset.NewFileStorage(t.TempDir())
+		mem            = nft.NewMemory()
+		_, newOwner, _ = randomAccount(rng, 0)
+		_, owner, acc  = randomAccount(rng, 1)
+		tv             = acc.Values.OrderedValues()[0]
+		id             = value.MustAsBigInts(tv.Value)[0]
+		// moves the NFT to newOwner after the server read the owner's NFTs,
+		// like a concurrent balance update of newOwner
+		storage = &transferringStorage{Storage: mem, tkn: nft.NFT{Token: tv.Token, ID: id, Owner: newOwner}}
+		srv     = nftserv.New(storage, assets, nftserv.ServerConfig{})
+	)
+	srv.UpdateBalance(owner, acc)
+
+	storage.after = "GetByOwner"
+	srv.UpdateBalance(owner, tee.Account{Values: value.TokenValues(tv.Token, &value.IDSet{})})
+	tkn, err := mem.Get(tv.Token, id)
+	require.NoError(err)
+	require.Equal(newOwner, tkn.Owner)
+	require.Equal(nft.StateOwned, tkn.State, "NFT of new owner marked as withdrawn")
+}
+
+// transferringStorage upserts tkn after the next call of method after, which
+// is either "GetByOwner" or "Get", like a concurrent balance update.
+type transferringStorage struct {
+	nft.Storage
+	tkn   nft.NFT
+	after string
+}
+
+func (s *transferringStorage) GetByOwner(owner common.Address) ([]nft.NFT, error) {
+	tkns, err := s.Storage.GetByOwner(owner)
+	if err == nil {
+		err = s.transfer("GetByOwner")
+	}
+	return tkns, err
+}
+
+func (s *transferringStorage) Get(token common.Address, id *big.Int) (nft.NFT, error) {
+	tkn, err := s.Storage.Get(token, id)
+	if err == nil {
+		err = s.transfer("Get")
+	}
+	return tkn, err
+}
+
+func (s *transferringStorage) transfer(method string) error {
+	if s.after != method {
+		return nil
+	}
+	s.after = ""
+	return s.Storage.Upsert(s.tkn)
+}
+
+func TestServerLimits(t *testing.T) {
+	const limitsPort = port + 1
+	var (
+		require   = require.New(t)
+		assets, _ = asset.NewFileStorage(t.TempDir())
+		srv       = nftserv.New(nft.NewMemory(), assets, nftserv.ServerConfig{
+			Host:              host,
+			Port:              limitsPort,
+			ReadHeaderTimeout: 1,
+			MaxConns:          1,
+		})
+		srverr    = make(chan error, 1)
+		addr      = net.JoinHostPort(host, strconv.Itoa(limitsPort))
+		statusURL = fmt.Sprintf("http://%s/status", addr)
+		client    = &http.Client{Timeout: 200 * time.Millisecond}
+	)
+	go func() {
+		srverr <- srv.Serve()
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(srv.Shutdown(ctx))
+		require.ErrorIs(<-srverr, http.ErrServerClosed)
+	}()
+
+	var conn net.Conn
+	require.Eventually(func() bool {
+		var err error
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, time.Second, 2*time.Millisecond)
+	// the idle connection occupies the only slot
+	_, err := client.Get(statusURL)
+	require.Error(err)
+
+	// but is closed after the header timeout
+	_, err = conn.Write([]byte("GET /status HTTP/1.1\r\n"))
+	require.NoError(err)
+	require.NoError(conn.SetReadDeadline(time.Now().Add(3 * time.Second)))
+	_, err = io.ReadAll(conn)
+	require.NoError(err, "connection closed by server")
+	conn.Close()
+
+	require.Eventually(func() bool {
+		resp, err := client.Get(statusURL)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	// Sign-In with Ethereum is disabled without siweDomain
+	resp, err := client.Post(fmt.Sprintf("http://%s/auth/login", addr), "application/json", strings.NewReader("{}"))
+	require.NoError(err)
+	resp.Body.Close()
+	require.Equal(http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestServerEvents(t *testing.T) {
+	const eventsPort = port + 2
+	var (
+		require   = require.New(t)
+		rng       = ptest.Prng(t)
+		assets, _ = asset.NewFileStorage(t.TempDir())
+		srv       = nftserv.New(nft.NewMemory(), assets, nftserv.ServerConfig{
+			Host:         host,
+			Port:         eventsPort,
+			EventLogSize: 3,
+		})
+		srverr             = make(chan error, 1)
+		eventsURL          = fmt.Sprintf("http://%s/events", net.JoinHostPort(host, strconv.Itoa(eventsPort)))
+		_, owner, acc      = randomAccount(rng, 2)
+		_, other, otherAcc = randomAccount(rng, 1)
+	)
+	go func() {
+		srverr <- srv.Serve()
+	}()
+	require.Eventually(func() bool {
+		resp, err := http.Get(eventsURL + "?owner=invalid")
+		if err != nil {
+			return false
+		}
+		requireError(t, resp, http.StatusBadRequest, nftserv.CodeBadRequest)
+		return true
+	}, time.Second, 2*time.Millisecond)
+
+	resp, err := http.Get(eventsURL + "?owner=" + owner.Hex())
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	require.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	stream := readEvents(resp.Body)
+
+	// create events of owner, other's NFT is filtered out
+	srv.UpdateBalance(owner, acc)
+	srv.UpdateBalance(other, otherAcc)
+	tv := acc.Values.OrderedValues()[0]
+	ids := value.MustAsBigInts(tv.Value)
+	for i := uint64(1); i <= 2; i++ {
+		e := requireEvent(t, stream, nftserv.EventCreate)
+		require.Equal(i, e.EventID)
+		require.Equal(tv.Token, e.Token)
+		require.Equal(owner, e.Owner)
+	}
+
+	// owner sees the transfer of its NFT to other
+	otherAcc.Values[tv.Token] = &value.IDSet{ids[0]}
+	srv.UpdateBalance(other, otherAcc)
+	e := requireEvent(t, stream, nftserv.EventOwner)
+	require.EqualValues(4, e.EventID)
+	require.Equal(ids[0].Text(10), e.ID)
+	require.Equal(other, e.Owner)
+	require.Equal(&owner, e.PreviousOwner)
+
+	// resume after event 2 from the event log
+	req, err := http.NewRequest(http.MethodGet, eventsURL, nil)
+	require.NoError(err)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	resumed := readEvents(resp.Body)
+	require.EqualValues(3, requireEvent(t, resumed, nftserv.EventCreate).EventID)
+	require.EqualValues(4, requireEvent(t, resumed, nftserv.EventOwner).EventID)
+
+	// event 1 is evicted from the log of size 3, so clients must reset
+	resp, err = http.Get(eventsURL + "?lastEventId=0")
+	require.NoError(err)
+	requireStatus(t, resp, http.StatusOK)
+	reset := readEvents(resp.Body)
+	require.EqualValues(1, requireEvent(t, reset, nftserv.EventReset).EventID)
+	require.EqualValues(2, requireEvent(t, reset, nftserv.EventCreate).EventID)
+
+	// open streams don't block the shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(srv.Shutdown(ctx))
+	require.ErrorIs(<-srverr, http.ErrServerClosed)
+	for range stream {
+	}
 }
 
 func requireStatus(t testing.TB, resp *http.Response, code int) {
@@ -159,6 +945,19 @@ func requireStatus(t testing.TB, resp *http.Response, code int) {
 	}
 }
 
+// requireError requires that resp is an error response with the given HTTP
+// status and error code and returns it.
+func requireError(t testing.TB, resp *http.Response, status int, code string) nftserv.ErrorResponse {
+	t.Helper()
+	requireStatus(t, resp, status)
+	require.Equal(t, "application/json; charset=UTF-8", resp.Header.Get("Content-Type"))
+	var errResp nftserv.ErrorResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	require.Equal(t, code, errResp.Code)
+	require.NotEmpty(t, errResp.Message)
+	return errResp
+}
+
 func url(elems ...interface{}) string {
 	var s strings.Builder
 	s.WriteString(fmt.Sprintf("http://%s:%v", host, port))
@@ -168,21 +967,216 @@ func url(elems ...interface{}) string {
 	return s.String()
 }
 
+// readEvents reads the Server-Sent Events from body until it is closed.
+func readEvents(body io.ReadCloser) <-chan nftserv.Event {
+	events := make(chan nftserv.Event)
+	go func() {
+		defer close(events)
+		defer body.Close()
+		var (
+			e       nftserv.Event
+			scanner = bufio.NewScanner(body)
+		)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				events <- e
+				e = nftserv.Event{}
+			case strings.HasPrefix(line, "id: "):
+				e.EventID, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "event: "):
+				e.Type = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				id, typ := e.EventID, e.Type
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+					panic(err)
+				}
+				e.EventID, e.Type = id, typ
+			}
+		}
+	}()
+	return events
+}
+
+func requireEvent(t testing.TB, events <-chan nftserv.Event, typ string) nftserv.Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		require.True(t, ok, "event stream closed")
+		require.Equal(t, typ, e.Type)
+		return e
+	case <-time.After(time.Second):
+		require.FailNow(t, "no event received")
+	}
+	return nftserv.Event{}
+}
+
 func putAsJSON(url string, obj interface{}) (*http.Response, error) {
+	return sendAsJSON(http.MethodPut, url, obj, nil)
+}
+
+// putSigned PUTs tkn, signed with key. Entries of header are added to the
+// request headers.
+func putSigned(t testing.TB, key *ecdsa.PrivateKey, tkn nft.NFT, header http.Header) (*http.Response, error) {
+	signed := signedHeader(t, key, nftserv.ActionUpdate, updatePayload(tkn))
+	for k, v := range header {
+		signed[k] = v
+	}
+	return sendAsJSON(http.MethodPut, url("nft", tkn.Token.String(), tkn.ID), tkn, signed)
+}
+
+// updatePayload returns the payload that is signed to authorize an update of
+// tkn. The server ignores the state and royalty, so they are not part of the
+// payload.
+func updatePayload(tkn nft.NFT) nft.NFT {
+	tkn.State, tkn.Royalty = "", nil
+	return tkn
+}
+
+type rollbackPayload struct {
+	Token common.Address `json:"token"`
+	ID    string         `json:"id"`
+	Rev   uint64         `json:"rev"`
+}
+
+type royaltyPayload struct {
+	Token   common.Address `json:"token"`
+	ID      string         `json:"id"`
+	Royalty *nft.Royalty   `json:"royalty"`
+}
+
+// readHeader returns the request headers authenticating a read of the URL path
+// elems by key.
+func readHeader(t testing.TB, key *ecdsa.PrivateKey, elems ...interface{}) http.Header {
+	t.Helper()
+	var path strings.Builder
+	for _, el := range elems {
+		path.WriteString(fmt.Sprintf("/%v", el))
+	}
+	return signedHeader(t, key, nftserv.ActionRead, map[string]string{"path": path.String()})
+}
+
+func getWithHeader(t testing.TB, url string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := new(http.Client).Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func getNonce(t testing.TB) string {
+	t.Helper()
+	resp, err := http.Get(url("auth", "nonce"))
+	require.NoError(t, err)
+	requireStatus(t, resp, http.StatusOK)
+	var nonce struct{ Nonce string }
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&nonce))
+	return nonce.Nonce
+}
+
+// signedHeader requests a nonce from the server and returns the request
+// headers authorizing action with payload, signed by key.
+func signedHeader(t testing.TB, key *ecdsa.PrivateKey, action string, payload interface{}) http.Header {
+	t.Helper()
+	return signWithNonce(t, key, action, payload, getNonce(t))
+}
+
+// signWithNonce is like signedHeader, but uses the given nonce.
+func signWithNonce(t testing.TB, key *ecdsa.PrivateKey, action string, payload interface{}, nonce string) http.Header {
+	t.Helper()
+	// encode the payload like JavaScript's JSON.stringify, without escaping
+	// HTML characters
+	var data bytes.Buffer
+	enc := json.NewEncoder(&data)
+	enc.SetEscapeHTML(false)
+	require.NoError(t, enc.Encode(payload))
+	msg := fmt.Sprintf("NERD %s\n%s\nNonce: %s", action, bytes.TrimSpace(data.Bytes()), nonce)
+	hash := crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)))
+	sig, err := crypto.Sign(hash, key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27 // like personal_sign
+	return http.Header{
+		nftserv.NonceHeader:     []string{nonce},
+		nftserv.SignatureHeader: []string{hexutil.Encode(sig)},
+	}
+}
+
+// login signs in to the server with a Sign-In with Ethereum message signed by
+// key and returns the session token.
+func login(t testing.TB, key *ecdsa.PrivateKey, addr common.Address) string {
+	t.Helper()
+	resp := sendLogin(t, key, addr, fmt.Sprintf("%s:%d", host, port))
+	requireStatus(t, resp, http.StatusOK)
+	var session struct{ Token string }
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	return session.Token
+}
+
+// sendLogin POSTs a Sign-In with Ethereum message for domain, signed by key, to
+// the server. The request's Host header is set to domain.
+func sendLogin(t testing.TB, key *ecdsa.PrivateKey, addr common.Address, domain string) *http.Response {
+	t.Helper()
+	msg := fmt.Sprintf("%s wants you to sign in with your Ethereum account:\n%s\n\n"+
+		"Sign in to NERD.\n\nURI: http://%s\nVersion: 1\nChain ID: 1337\nNonce: %s\nIssued At: %s",
+		domain, addr.Hex(), domain, getNonce(t), time.Now().UTC().Format(time.RFC3339))
+	hash := crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(msg), msg)))
+	sig, err := crypto.Sign(hash, key)
+	require.NoError(t, err)
+	sig[crypto.RecoveryIDOffset] += 27
+
+	data, err := json.Marshal(map[string]string{"message": msg, "signature": hexutil.Encode(sig)})
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, url("auth", "login"), bytes.NewReader(data))
+	require.NoError(t, err)
+	req.Host = domain
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := new(http.Client).Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+// sendPatch PATCHes url with JSON Merge Patch patch. Entries of header are
+// added to the request headers.
+func sendPatch(t testing.TB, url, patch string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPatch, url, strings.NewReader(patch))
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", nftserv.MergePatchContentType)
+	resp, err := new(http.Client).Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func sendAsJSON(method, url string, obj interface{}, header http.Header) (*http.Response, error) {
 	data, err := json.Marshal(obj)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(data))
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	return new(http.Client).Do(req)
 }
 
-func randomAccount(rng *rand.Rand, numNFTs int) (common.Address, tee.Account) {
-	return eth.NewRandomAddress(rng), tee.Account{
+func randomAccount(rng *rand.Rand, numNFTs int) (*ecdsa.PrivateKey, common.Address, tee.Account) {
+	key, err := ecdsa.GenerateKey(crypto.S256(), rng)
+	if err != nil {
+		panic(err)
+	}
+	return key, crypto.PubkeyToAddress(key.PublicKey), tee.Account{
 		Nonce: rng.Uint64(),
 		Values: value.TokenValues(
 			eth.NewRandomAddress(rng),
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSessionTTL is the session lifetime if none is configured.
+const DefaultSessionTTL = 24 * time.Hour
+
+type (
+	// sessionSigner issues and verifies HMAC-signed session tokens. A token is
+	// the base64url encoding of the address and the big-endian unix expiry
+	// time, followed by a dot and the base64url encoded HMAC-SHA256 thereof.
+	sessionSigner struct {
+		key []byte
+		ttl time.Duration
+	}
+
+	// sessionCtxKey is the request context key of the session address.
+	sessionCtxKey struct{}
+
+	loginRequest struct {
+		Message   string `json:"message"`
+		Signature string `json:"signature"`
+	}
+
+	loginResponse struct {
+		Token   string         `json:"token"`
+		Address common.Address `json:"address"`
+		Expires time.Time      `json:"expires"`
+	}
+)
+
+// newSessionSigner returns a sessionSigner with the given HMAC key and session
+// ttl. If key is empty, a random key is used, so sessions don't survive
+// restarts. If ttl is 0, DefaultSessionTTL is used.
+func newSessionSigner(key []byte, ttl time.Duration) (*sessionSigner, error) {
+	if len(key) == 0 {
+		key = make([]byte, sha256.Size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("reading randomness: %w", err)
+		}
+	}
+	if ttl == 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &sessionSigner{key: key, ttl: ttl}, nil
+}
+
+// Issue issues a session token for addr, expiring at exp.
+func (ss *sessionSigner) Issue(addr common.Address, exp time.Time) string {
+	payload := make([]byte, common.AddressLength+8)
+	copy(payload, addr.Bytes())
+	binary.BigEndian.PutUint64(payload[common.AddressLength:], uint64(exp.Unix()))
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(ss.mac(payload))
+}
+
+// Verify verifies session token and returns its address.
+func (ss *sessionSigner) Verify(token string, now time.Time) (common.Address, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return common.Address{}, errors.New("malformed session token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(payload) != common.AddressLength+8 {
+		return common.Address{}, errors.New("malformed session token")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(mac, ss.mac(payload)) {
+		return common.Address{}, errors.New("invalid session token")
+	}
+	exp := time.Unix(int64(binary.BigEndian.Uint64(payload[common.AddressLength:])), 0)
+	if !now.Before(exp) {
+		return common.Address{}, errors.New("session expired")
+	}
+	return common.BytesToAddress(payload[:common.AddressLength]), nil
+}
+
+func (ss *sessionSigner) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, ss.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// SessionMiddleware authenticates requests carrying a session token in header
+// "Authorization: Bearer {token}". The session address is then used as the
+// verified address of the caller. Requests with an invalid token are rejected.
+func (s *Server) SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth {
+			httpError(w, "Unsupported authorization scheme", http.StatusUnauthorized)
+			return
+		}
+		addr, err := s.sessions.Verify(token, time.Now())
+		if err != nil {
+			httpError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionCtxKey{}, addr)))
+	})
+}
+
+// sessionAddress returns the session address of request r, if it has a valid
+// session.
+func sessionAddress(r *http.Request) (common.Address, bool) {
+	addr, ok := r.Context().Value(sessionCtxKey{}).(common.Address)
+	return addr, ok
+}
+
+// handlePOSTlogin verifies an EIP-4361 (Sign-In with Ethereum) message and
+// its personal_sign signature and returns a session token. The message's nonce
+// must be issued by GET /auth/nonce.
+func (s *Server) handlePOSTlogin(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.SIWEDomain == "" {
+		httpError(w, "Sign-In with Ethereum is not configured", http.StatusNotImplemented)
+		return
+	}
+	if !s.limitPayload(w, r) {
+		return
+	}
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		payloadError(w, "Error decoding login request from payload: ", err)
+		return
+	}
+	msg, err := parseSIWEMessage(req.Message)
+	if err != nil {
+		httpError(w, "Invalid SIWE message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	if err := msg.verify(s.cfg.SIWEDomain, s.cfg.ChainID, now); err != nil {
+		httpError(w, "Invalid SIWE message: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	sig, err := hexutil.Decode(req.Signature)
+	if err != nil {
+		httpError(w, "Error decoding signature: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	signer, err := recoverSigner(personalHash([]byte(req.Message)), sig)
+	if err != nil {
+		httpError(w, "Error recovering signer: "+err.Error(), http.StatusUnauthorized)
+		return
+	} else if signer != msg.Address {
+		httpError(w, "Signer doesn't match SIWE address", http.StatusUnauthorized)
+		return
+	}
+	// The nonce is only consumed after verifying the signature, so that
+	// nobody else can use it up.
+	if !s.nonces.Consume(msg.Nonce) {
+		httpError(w, "Unknown, expired or reused nonce", http.StatusUnauthorized)
+		return
+	}
+
+	exp := now.Add(s.sessions.ttl)
+	if msg.ExpirationTime != nil && msg.ExpirationTime.Before(exp) {
+		exp = *msg.ExpirationTime
+	}
+	resp := loginResponse{
+		Token:   s.sessions.Issue(signer, exp),
+		Address: signer,
+		Expires: exp.Truncate(time.Second),
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error JSON-marshalling login response: %v", err)
+	}
+}
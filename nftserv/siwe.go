@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	siwePreamble = " wants you to sign in with your Ethereum account:"
+	siweVersion  = "1"
+)
+
+// siweMessage is a parsed EIP-4361 Sign-In with Ethereum message.
+type siweMessage struct {
+	Domain         string
+	Address        common.Address
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        uint64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime *time.Time
+	NotBefore      *time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// parseSIWEMessage parses an EIP-4361 message.
+func parseSIWEMessage(msg string) (*siweMessage, error) {
+	lines := strings.Split(msg, "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], siwePreamble) {
+		return nil, errors.New("missing preamble")
+	}
+	m := &siweMessage{Domain: strings.TrimSuffix(lines[0], siwePreamble)}
+	if i := strings.Index(m.Domain, "://"); i >= 0 {
+		m.Domain = m.Domain[i+3:] // optional scheme
+	}
+	if !common.IsHexAddress(lines[1]) || !strings.HasPrefix(lines[1], "0x") {
+		return nil, fmt.Errorf("invalid address %q", lines[1])
+	}
+	m.Address = common.HexToAddress(lines[1])
+
+	// The optional statement is enclosed in empty lines before the fields.
+	i := 2
+	var statement []string
+	for ; i < len(lines) && !strings.HasPrefix(lines[i], "URI: "); i++ {
+		if lines[i] != "" {
+			statement = append(statement, lines[i])
+		}
+	}
+	m.Statement = strings.Join(statement, "\n")
+
+	var haveIssuedAt bool
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if line == "Resources:" {
+			for _, res := range lines[i+1:] {
+				if !strings.HasPrefix(res, "- ") {
+					return nil, fmt.Errorf("invalid resource line %q", res)
+				}
+				m.Resources = append(m.Resources, strings.TrimPrefix(res, "- "))
+			}
+			break
+		}
+		kv := strings.SplitN(line, ": ", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid field line %q", line)
+		}
+		var err error
+		switch k, v := kv[0], kv[1]; k {
+		case "URI":
+			m.URI = v
+		case "Version":
+			m.Version = v
+		case "Chain ID":
+			m.ChainID, err = strconv.ParseUint(v, 10, 64)
+		case "Nonce":
+			m.Nonce = v
+		case "Issued At":
+			m.IssuedAt, err = time.Parse(time.RFC3339, v)
+			haveIssuedAt = true
+		case "Expiration Time":
+			m.ExpirationTime, err = parseTimePtr(v)
+		case "Not Before":
+			m.NotBefore, err = parseTimePtr(v)
+		case "Request ID":
+			m.RequestID = v
+		default:
+			return nil, fmt.Errorf("unknown field %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing field %q: %w", kv[0], err)
+		}
+	}
+
+	if m.URI == "" || m.Version == "" || m.Nonce == "" || !haveIssuedAt {
+		return nil, errors.New("missing required field")
+	}
+	return m, nil
+}
+
+func parseTimePtr(v string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// verify checks that message m is a valid sign-in to domain on chain chainID
+// at time now. The nonce and signature are not checked.
+func (m *siweMessage) verify(domain string, chainID uint64, now time.Time) error {
+	switch {
+	case m.Domain != domain:
+		return fmt.Errorf("domain mismatch: %s", m.Domain)
+	case m.Version != siweVersion:
+		return fmt.Errorf("unsupported version %s", m.Version)
+	case m.ChainID != chainID:
+		return fmt.Errorf("chain id mismatch: %d", m.ChainID)
+	case m.ExpirationTime != nil && !now.Before(*m.ExpirationTime):
+		return errors.New("message expired")
+	case m.NotBefore != nil && now.Before(*m.NotBefore):
+		return errors.New("message not yet valid")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/perun-network/nerd-op/asset"
+	"github.com/perun-network/nerd-op/nft"
+)
+
+var (
+	errAssetTooLarge   = errors.New("asset too large")
+	errPayloadTooLarge = errors.New("payload too large")
+)
+
+// sniffLen is the number of bytes http.DetectContentType considers at most.
+const sniffLen = 512
+
+type (
+	uploadResponse struct {
+		AssetID *big.Int `json:"assetId"`
+		SHA256  string   `json:"sha256"`
+	}
+
+	// limitReader reads at most n bytes from r and fails with err if r holds
+	// more.
+	limitReader struct {
+		r   io.Reader
+		n   int64
+		err error
+	}
+)
+
+// handlePOSTasset stores the uploaded asset in the request body and returns
+// its id and SHA-256 digest. Identical uploads result in the same id. The
+// request must be authenticated by the owner of an NFT. If the request has no
+// media type, the type is detected from the content and must be a media type,
+// too.
+func (s *Server) handlePOSTasset(w http.ResponseWriter, r *http.Request) {
+	assets, ok := s.assets.(asset.WritableStorage)
+	if !ok {
+		httpError(w, "Asset storage doesn't support uploads", http.StatusNotImplemented)
+		return
+	}
+	if s.cfg.MaxAssetSize > 0 && r.ContentLength > s.cfg.MaxAssetSize {
+		httpError(w, errAssetTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	ctype, ok := uploadContentType(r)
+	if !ok {
+		httpError(w, "Asset must be an image, video, audio or model", http.StatusUnsupportedMediaType)
+		return
+	}
+	uploader, err := s.authenticate(r, ActionUpload, pathPayload{Path: r.URL.Path}, s.ownsNFT)
+	if errors.Is(err, errNotAuthorized) {
+		httpError(w, fmt.Sprintf("Uploader %v doesn't own an NFT", uploader), http.StatusForbidden)
+		return
+	} else if err != nil {
+		httpError(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if s.cfg.MaxAssetSize > 0 {
+		body = &limitReader{r: body, n: s.cfg.MaxAssetSize, err: errAssetTooLarge}
+	}
+	if ctype == "" {
+		br := bufio.NewReaderSize(body, sniffLen)
+		head, err := br.Peek(sniffLen)
+		if errors.Is(err, errAssetTooLarge) {
+			httpError(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil && err != io.EOF {
+			httpError(w, "Error reading asset: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ctype = http.DetectContentType(head); !isMediaType(ctype) {
+			httpError(w, fmt.Sprintf("Asset detected as %s, must be an image, video, audio or model", ctype),
+				http.StatusUnsupportedMediaType)
+			return
+		}
+		body = br
+	}
+	h := sha256.New()
+	id, err := assets.Put(io.TeeReader(body, h), ctype, uploader)
+	if errors.Is(err, errAssetTooLarge) {
+		httpError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		httpError(w, "Error storing asset: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Debugf("Asset %v uploaded by %v", id, uploader)
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	resp := uploadResponse{AssetID: id, SHA256: hex.EncodeToString(h.Sum(nil))}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error JSON-marshalling upload response: %v", err)
+	}
+}
+
+// ownsNFT reports whether addr owns an NFT that is not withdrawn.
+func (s *Server) ownsNFT(addr common.Address) bool {
+	tkns, err := s.nfts.GetByOwner(addr)
+	if err != nil {
+		log.Errorf("Error getting NFTs of %v: %v", addr, err)
+		return false
+	}
+	for _, tkn := range tkns {
+		if tkn.State != nft.StateWithdrawn {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1] // read one more byte to detect excess
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return n, l.err
+	}
+	return n, err
+}
+
+// uploadContentType returns the media type of upload request r. Generic or
+// invalid types are ignored so that the type is detected from the content by
+// handlePOSTasset.
+// It returns false if the type isn't a media type, see isMediaType.
+func uploadContentType(r *http.Request) (string, bool) {
+	mediatype, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediatype == "application/octet-stream" {
+		return "", true
+	} else if !isMediaType(mediatype) {
+		return "", false
+	}
+	return mime.FormatMediaType(mediatype, params), true
+}
+
+// isMediaType reports whether MIME type ctype is an image, video, audio or 3D
+// model type. Other assets, like HTML, are only served as attachments.
+func isMediaType(ctype string) bool {
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+	switch strings.SplitN(mediatype, "/", 2)[0] {
+	case "image", "video", "audio", "model":
+		return true
+	}
+	return false
+}
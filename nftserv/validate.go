@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+
+package nftserv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/perun-network/nerd-op/asset"
+	"github.com/perun-network/nerd-op/nft"
+)
+
+// limitedBody limits the request body to MaxPayloadSize bytes.
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// limitPayload limits the body of request r to the configured MaxPayloadSize
+// so that reading more fails with errPayloadTooLarge, see payloadError. If the
+// request's Content-Length already exceeds the limit, it responds with 413
+// and returns false.
+func (s *Server) limitPayload(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.MaxPayloadSize <= 0 {
+		return true
+	} else if r.ContentLength > int64(s.cfg.MaxPayloadSize) {
+		httpError(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	r.Body = limitedBody{
+		Reader: &limitReader{r: r.Body, n: int64(s.cfg.MaxPayloadSize), err: errPayloadTooLarge},
+		Closer: r.Body,
+	}
+	return true
+}
+
+// payloadError responds with 413 if reading the payload failed with err
+// because it is too large, see limitPayload, and with 400 and message msg
+// otherwise.
+func payloadError(w http.ResponseWriter, msg string, err error) {
+	if errors.Is(err, errPayloadTooLarge) {
+		httpError(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	httpError(w, msg+err.Error(), http.StatusBadRequest)
+}
+
+// validateNFT validates tkn, see nft.NFT.Validate, checking that its asset
+// exists in the asset storage. If it is invalid, it responds with an
+// ErrorResponse listing all invalid fields and returns false.
+func (s *Server) validateNFT(w http.ResponseWriter, tkn nft.NFT) bool {
+	if err := tkn.Validate(s.assetExists); err != nil {
+		storageError(w, "Error validating token: ", err)
+		return false
+	}
+	return true
+}
+
+// assetExists reports whether the asset with the given id exists in the asset
+// storage. The reserved id 0 never exists.
+func (s *Server) assetExists(id uint) (bool, error) {
+	if id == 0 {
+		return false, nil
+	}
+	a, err := s.assets.Get(new(big.Int).SetUint64(uint64(id)))
+	if errors.Is(err, asset.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, a.Close()
+}
+
+// authorizeAsset checks that owner may set the asset of one of its NFTs to
+// the asset with id assetID. If the asset was uploaded, owner must be one of
+// its uploaders. And no secret NFT of another owner may use the asset, since
+// it would be served publicly otherwise. If owner may not use the asset, it
+// responds with 403 and returns false.
+func (s *Server) authorizeAsset(w http.ResponseWriter, owner common.Address, assetID uint) bool {
+	if assets, ok := s.assets.(asset.WritableStorage); ok {
+		uploaders, err := assets.Uploaders(new(big.Int).SetUint64(uint64(assetID)))
+		if err != nil {
+			httpError(w, "Error reading asset uploaders: "+err.Error(), http.StatusInternalServerError)
+			return false
+		} else if len(uploaders) > 0 && !containsAddress(uploaders, owner) {
+			httpError(w, fmt.Sprintf("Asset %d was not uploaded by the token owner", assetID), http.StatusForbidden)
+			return false
+		}
+	}
+
+	users, err := s.nfts.GetByAsset(assetID)
+	if err != nil {
+		storageError(w, "Error reading NFTs of asset: ", err)
+		return false
+	}
+	for _, tkn := range users {
+		if tkn.Secret && tkn.Owner != owner {
+			httpError(w, fmt.Sprintf("Asset %d is used by a secret NFT of another owner", assetID), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}